@@ -13,10 +13,13 @@ import (
 	"github.com/rwrrioe/geomap/backend/pkg/entities"
 	"github.com/rwrrioe/geomap/backend/pkg/repository"
 	"github.com/twpayne/go-geom"
+	"github.com/twpayne/go-geom/encoding/geojson"
+	"github.com/twpayne/go-geom/xy"
 	"github.com/ybru-tech/georm"
 	"google.golang.org/genai"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type DistrictResponse struct {
@@ -68,7 +71,157 @@ func DbConnect() (repository.ProblemRepository, error) {
 }
 
 func DbMigrate(r repository.ProblemRepository) error {
-	r.GetDb().Db.AutoMigrate(&entities.District{}, &entities.Problem{})
+	db := r.GetDb().Db
+	if err := db.Exec("CREATE EXTENSION IF NOT EXISTS pg_trgm").Error; err != nil {
+		return err
+	}
+
+	if err := migrateProblemGeometry(db); err != nil {
+		return fmt.Errorf("problem geometry migration failed: %w", err)
+	}
+
+	if err := migrateProblemCreatedAt(db); err != nil {
+		return fmt.Errorf("problem created_at migration failed: %w", err)
+	}
+
+	err := db.AutoMigrate(&entities.District{}, &entities.Problem{}, &entities.ProblemDistrict{}, &entities.DistrictNeighbour{}, &entities.OSMAddress{}, &entities.OSMStreet{}, &entities.GazetteerEntry{}, &entities.POI{}, &entities.JobRun{}, &entities.OutboxEvent{},
+		&entities.WebhookSubscription{}, &entities.WebhookDelivery{})
+	if err != nil {
+		return err
+	}
+
+	if err := db.Exec("CREATE INDEX IF NOT EXISTS idx_gazetteer_search ON gazetteer USING gin (search_text gin_trgm_ops)").Error; err != nil {
+		return err
+	}
+
+	return migrateDataVersions(db)
+}
+
+// migrateProblemGeometry widens problems.geom from Point to any geometry. The
+// problems_with_importance view depends on the column, so it is dropped and recreated
+// from its own definition around the type change.
+func migrateProblemGeometry(db *gorm.DB) error {
+	var geomType string
+	result := db.Raw(
+		`
+		SELECT type
+		FROM geometry_columns
+		WHERE f_table_schema = current_schema()
+		AND f_table_name = 'problems'
+		AND f_geometry_column = 'geom'
+		`).Scan(&geomType)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	// a fresh database gets the column from AutoMigrate
+	if result.RowsAffected == 0 || geomType == "GEOMETRY" {
+		return nil
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		var viewDef string
+		result := tx.Raw(
+			`
+			SELECT pg_get_viewdef(to_regclass('problems_with_importance'))
+			WHERE to_regclass('problems_with_importance') IS NOT NULL
+			`).Scan(&viewDef)
+		if result.Error != nil {
+			return result.Error
+		}
+
+		if viewDef != "" {
+			if err := tx.Exec("DROP VIEW problems_with_importance").Error; err != nil {
+				return err
+			}
+		}
+
+		if err := tx.Exec("ALTER TABLE problems ALTER COLUMN geom TYPE geometry(Geometry,4326)").Error; err != nil {
+			return err
+		}
+
+		if viewDef != "" {
+			return tx.Exec("CREATE VIEW problems_with_importance AS " + viewDef).Error
+		}
+
+		return nil
+	})
+}
+
+// migrateProblemCreatedAt backfills created_at of problems reported before it was recorded,
+// time filters, frames and the stale sweep skip NULLs. AutoMigrate then makes the column NOT NULL.
+func migrateProblemCreatedAt(db *gorm.DB) error {
+	if !db.Migrator().HasColumn(&entities.Problem{}, "CreatedAt") {
+		return nil
+	}
+
+	return db.Exec("UPDATE problems SET created_at = now() WHERE created_at IS NULL").Error
+}
+
+// migrateDataVersions installs the triggers bumping data_versions on every change of problems,
+// caches built from the data compare against the version instead of being invalidated by hand
+func migrateDataVersions(db *gorm.DB) error {
+	if err := db.AutoMigrate(&entities.DataVersion{}, &entities.CachedHeatMap{}); err != nil {
+		return err
+	}
+
+	// start at 1 so heatmaps cached before versioning, stored with version 0, are rebuilt
+	err := db.Exec("INSERT INTO data_versions (name, version, updated_at) VALUES (?, 1, now()) ON CONFLICT DO NOTHING",
+		repository.DataVersionProblems).Error
+	if err != nil {
+		return err
+	}
+
+	for _, stmt := range []string{
+		// statement triggers also fire for statements that touched nothing, the transition
+		// tables tell whether rows were really inserted, changed or deleted
+		`
+		CREATE OR REPLACE FUNCTION bump_data_version() RETURNS trigger AS $$
+		BEGIN
+			IF TG_OP = 'INSERT' AND NOT EXISTS (SELECT 1 FROM new_rows) THEN
+				RETURN NULL;
+			ELSIF TG_OP = 'DELETE' AND NOT EXISTS (SELECT 1 FROM old_rows) THEN
+				RETURN NULL;
+			ELSIF TG_OP = 'UPDATE' AND NOT EXISTS (
+				SELECT n::text FROM new_rows n
+				EXCEPT ALL
+				SELECT o::text FROM old_rows o
+			) THEN
+				RETURN NULL;
+			END IF;
+
+			UPDATE data_versions SET version = version + 1, updated_at = now() WHERE name = TG_ARGV[0];
+			RETURN NULL;
+		END
+		$$ LANGUAGE plpgsql`,
+		"DROP TRIGGER IF EXISTS problems_data_version ON problems",
+		"DROP TRIGGER IF EXISTS problems_data_version_insert ON problems",
+		"DROP TRIGGER IF EXISTS problems_data_version_update ON problems",
+		"DROP TRIGGER IF EXISTS problems_data_version_delete ON problems",
+		"DROP TRIGGER IF EXISTS problems_data_version_truncate ON problems",
+		// transition tables need one trigger per event
+		`
+		CREATE TRIGGER problems_data_version_insert
+		AFTER INSERT ON problems REFERENCING NEW TABLE AS new_rows
+		FOR EACH STATEMENT EXECUTE FUNCTION bump_data_version('problems')`,
+		`
+		CREATE TRIGGER problems_data_version_update
+		AFTER UPDATE ON problems REFERENCING OLD TABLE AS old_rows NEW TABLE AS new_rows
+		FOR EACH STATEMENT EXECUTE FUNCTION bump_data_version('problems')`,
+		`
+		CREATE TRIGGER problems_data_version_delete
+		AFTER DELETE ON problems REFERENCING OLD TABLE AS old_rows
+		FOR EACH STATEMENT EXECUTE FUNCTION bump_data_version('problems')`,
+		`
+		CREATE TRIGGER problems_data_version_truncate
+		AFTER TRUNCATE ON problems
+		FOR EACH STATEMENT EXECUTE FUNCTION bump_data_version('problems')`,
+	} {
+		if err := db.Exec(stmt).Error; err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -90,9 +243,194 @@ func ParseDistrict(db *gorm.DB) error {
 		fmt.Println("parsed to the db, #", i)
 	}
 
+	if err := repository.NewProblemRepo(db).RebuildDistrictAdjacency(context.Background()); err != nil {
+		return err
+	}
+	fmt.Println("built district adjacency")
+
 	return nil
 }
 
+// ParseAddresses imports a geojson export of OSM address points and street lines,
+// e.g. produced by osmium export from the city extract
+func ParseAddresses(db *gorm.DB, path string) error {
+	var collection geojson.FeatureCollection
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if err := json.NewDecoder(file).Decode(&collection); err != nil {
+		return fmt.Errorf("error %w", err)
+	}
+
+	var addresses, streets int
+	for _, f := range collection.Features {
+		switch g := f.Geometry.(type) {
+		case *geom.Point:
+			street, _ := f.Properties["addr:street"].(string)
+			if street == "" {
+				continue
+			}
+			housenumber, _ := f.Properties["addr:housenumber"].(string)
+			db.Create(&entities.OSMAddress{
+				Street:      street,
+				HouseNumber: housenumber,
+				Geom:        georm.New(g),
+			})
+			addresses++
+		case *geom.LineString:
+			name, _ := f.Properties["name"].(string)
+			if _, ok := f.Properties["highway"]; !ok || name == "" {
+				continue
+			}
+			db.Create(&entities.OSMStreet{
+				Name: name,
+				Geom: georm.New(g),
+			})
+			streets++
+		}
+	}
+
+	fmt.Println("parsed to the db, addresses:", addresses, "streets:", streets)
+	return nil
+}
+
+// ParseGazetteer imports a geojson export of OSM address points and named places
+// with their russian and kazakh names into the search gazetteer
+func ParseGazetteer(db *gorm.DB, path string) error {
+	var collection geojson.FeatureCollection
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if err := json.NewDecoder(file).Decode(&collection); err != nil {
+		return fmt.Errorf("error %w", err)
+	}
+
+	var parsed int
+	for _, f := range collection.Features {
+		point, ok := f.Geometry.(*geom.Point)
+		if !ok {
+			continue
+		}
+
+		entry := gazetteerEntryFromProperties(f.Properties)
+		if entry == nil {
+			continue
+		}
+		entry.Geom = georm.New(point)
+
+		db.Create(entry)
+		parsed++
+	}
+
+	fmt.Println("parsed to the db, gazetteer entries:", parsed)
+	return nil
+}
+
+func gazetteerEntryFromProperties(props map[string]interface{}) *entities.GazetteerEntry {
+	prop := func(key string) string {
+		v, _ := props[key].(string)
+		return v
+	}
+
+	placeName := prop("name")
+	entry := &entities.GazetteerEntry{
+		Kind:   "place",
+		Name:   placeName,
+		NameRU: prop("name:ru"),
+		NameKK: prop("name:kk"),
+	}
+
+	if street := prop("addr:street"); street != "" {
+		entry.Kind = "address"
+		entry.Name = street
+		entry.HouseNumber = prop("addr:housenumber")
+	}
+
+	if entry.Name == "" {
+		return nil
+	}
+
+	entry.SearchText = strings.ToLower(strings.Join(
+		[]string{entry.Name, entry.HouseNumber, placeName, entry.NameRU, entry.NameKK}, " "))
+	return entry
+}
+
+// ParsePOIs imports schools, hospitals, clinics and bus stops from a geojson export
+// of the city extract, features are upserted by their osm id so the import can be rerun
+func ParsePOIs(db *gorm.DB, path string) error {
+	var collection geojson.FeatureCollection
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if err := json.NewDecoder(file).Decode(&collection); err != nil {
+		return fmt.Errorf("error %w", err)
+	}
+
+	parsed := make(map[string]int)
+	for _, f := range collection.Features {
+		category := poiCategory(f.Properties)
+		if category == "" || f.Geometry == nil {
+			continue
+		}
+
+		// buildings come as polygons, keep their centroid
+		centroid, err := xy.Centroid(f.Geometry)
+		if err != nil {
+			continue
+		}
+
+		name, _ := f.Properties["name"].(string)
+		poi := entities.POI{
+			Category: category,
+			Name:     name,
+			Geom:     georm.New(geom.NewPointFlat(geom.XY, []float64{centroid.X(), centroid.Y()})),
+		}
+
+		if f.ID != "" {
+			poi.OSMID = &f.ID
+			db.Clauses(clause.OnConflict{
+				Columns:   []clause.Column{{Name: "osm_id"}},
+				DoUpdates: clause.AssignmentColumns([]string{"category", "name", "geom"}),
+			}).Create(&poi)
+		} else {
+			db.Create(&poi)
+		}
+		parsed[category]++
+	}
+
+	fmt.Println("parsed to the db, pois:", parsed)
+	return nil
+}
+
+func poiCategory(props map[string]interface{}) string {
+	prop := func(key string) string {
+		v, _ := props[key].(string)
+		return v
+	}
+
+	switch {
+	case prop("amenity") == "school":
+		return entities.POISchool
+	case prop("amenity") == "hospital":
+		return entities.POIHospital
+	case prop("amenity") == "clinic", prop("amenity") == "doctors", prop("healthcare") == "clinic":
+		return entities.POIClinic
+	case prop("highway") == "bus_stop", prop("public_transport") == "platform" && prop("bus") == "yes":
+		return entities.POIBusStop
+	}
+
+	return ""
+}
+
 func GenerateProblems(ctx context.Context, db *gorm.DB) error {
 	fmt.Println("generating func")
 	problemsResponse := newProblemsResponse()
@@ -174,8 +512,9 @@ ProblemID — это первичный ключ и он не должен по
 		lon, _ := strconv.ParseFloat(parts[0], 64)
 		lat, _ := strconv.ParseFloat(parts[1], 64)
 
-		if lon < 60 {
-			lon, lat = lat, lon
+		if !entities.AlmatyEnvelope.Contains(lon, lat) {
+			fmt.Println("skipped, outside the city:", p.Geom)
+			continue
 		}
 
 		point := geom.NewPointFlat(geom.XY, []float64{lon, lat})
@@ -185,7 +524,7 @@ ProblemID — это первичный ключ и он не должен по
 			Description: p.Description,
 			Status:      p.Status,
 			Importance:  p.Importance,
-			Geom:        georm.New(point),
+			Geom:        georm.New[geom.T](point),
 		}
 		db.Create(&problem)
 		fmt.Println("written", i)
@@ -7,8 +7,12 @@ import (
 	"database/sql/driver"
 	"encoding/json"
 	"fmt"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/twpayne/go-geom"
+	"github.com/twpayne/go-geom/xy"
 	"github.com/ybru-tech/georm"
 )
 
@@ -35,17 +39,23 @@ type District struct {
 	Problems   []Problem `gorm:"foreignKey:DistrictID;references:DistrictID"`
 }
 
+// ProblemGeometry holds a Point, LineString or Polygon
+type ProblemGeometry = georm.Geometry[geom.T]
+
 type Problem struct {
-	ProblemID   int `gorm:"primaryKey;autoIncrement;uniqueIndex:idx_problemid"`
-	DistrictID  int
+	ProblemID   int  `gorm:"primaryKey;autoIncrement;uniqueIndex:idx_problemid"`
+	DistrictID  *int // nil for unassigned reports waiting for review
 	District    District
-	Geom        georm.Point `gorm:"type:geometry(Point,4326)"`
-	Name        string      `gorm:"not null"`
-	Description string      `gorm:"not null"`
-	ImageURL    string      `gorm:"column:image_url"`
-	Importance  float64     `gorm:"not null"`
-	Status      string      `gorm:"not null"`
-	TypeId      int         `gorm:"not null"`
+	Geom        ProblemGeometry `gorm:"type:geometry(Geometry,4326)"`
+	Name        string          `gorm:"not null"`
+	Description string          `gorm:"not null"`
+	ImageURL    string          `gorm:"column:image_url"`
+	Importance  float64         `gorm:"not null"`
+	Status      string          `gorm:"not null"`
+	TypeId      int             `gorm:"not null"`
+	Address     string          `gorm:"column:address"`
+	NeedsReview bool            `gorm:"column:needs_review;not null;default:false"`
+	CreatedAt   time.Time       `gorm:"autoCreateTime;not null;default:now()"`
 }
 
 type ProblemResponseDTO struct {
@@ -64,8 +74,27 @@ type CreateProblemForm struct {
 	ImageURL    string `form:"image_url"`
 	Description string `form:"description"`
 	TypeID      int    `form:"type_id" binding:"required"`
+	Address     string `form:"address"`  // used when lat/lon are not given
+	Geometry    string `form:"geometry"` // geojson LineString or Polygon, replaces lat/lon
 	Lat         float64
 	Lon         float64
+	SRID        int // crs of Lat/Lon and Geometry, 0 means WGS84
+
+	DistrictID       int
+	DistrictStrategy string
+}
+
+// ProblemDistrict links a line or polygon problem to every district it crosses
+type ProblemDistrict struct {
+	ProblemID  int `gorm:"primaryKey;column:problem_id"`
+	DistrictID int `gorm:"primaryKey;column:district_id"`
+}
+
+// DistrictNeighbour is one directed edge of the district adjacency graph, built at import
+type DistrictNeighbour struct {
+	DistrictID   int     `gorm:"primaryKey;column:district_id"`
+	NeighbourID  int     `gorm:"primaryKey;column:neighbour_id"`
+	BorderLength float64 `gorm:"column:border_length"` // meters
 }
 
 type ProblemType struct {
@@ -73,6 +102,73 @@ type ProblemType struct {
 	TypeName string `gorm:"column:type"`
 }
 
+// OSM ENTITIES, imported from local extracts
+type OSMAddress struct {
+	AddressID   int         `gorm:"primaryKey;autoIncrement;column:address_id"`
+	Street      string      `gorm:"not null"`
+	HouseNumber string      `gorm:"column:housenumber"`
+	Geom        georm.Point `gorm:"type:geometry(Point,4326);index:idx_osm_addresses_geom,type:gist"`
+}
+
+func (OSMAddress) TableName() string {
+	return "osm_addresses"
+}
+
+type OSMStreet struct {
+	StreetID int              `gorm:"primaryKey;autoIncrement;column:street_id"`
+	Name     string           `gorm:"not null"`
+	Geom     georm.LineString `gorm:"type:geometry(LineString,4326);index:idx_osm_streets_geom,type:gist"`
+}
+
+func (OSMStreet) TableName() string {
+	return "osm_streets"
+}
+
+// GazetteerEntry is a searchable address or named place
+type GazetteerEntry struct {
+	EntryID     int         `gorm:"primaryKey;autoIncrement;column:entry_id"`
+	Kind        string      `gorm:"not null"` // "address" or "place"
+	Name        string      `gorm:"not null"`
+	NameRU      string      `gorm:"column:name_ru"`
+	NameKK      string      `gorm:"column:name_kk"`
+	HouseNumber string      `gorm:"column:housenumber"`
+	SearchText  string      `gorm:"column:search_text;not null"`
+	Geom        georm.Point `gorm:"type:geometry(Point,4326)"`
+}
+
+func (GazetteerEntry) TableName() string {
+	return "gazetteer"
+}
+
+// POI categories, all of them count as sensitive around a problem
+const (
+	POISchool   = "school"
+	POIHospital = "hospital"
+	POIClinic   = "clinic"
+	POIBusStop  = "bus_stop"
+)
+
+// POIWeights is how much one nearby poi of the category adds to problem importance
+var POIWeights = map[string]float64{
+	POISchool:   1.0,
+	POIHospital: 1.0,
+	POIClinic:   0.75,
+	POIBusStop:  0.25,
+}
+
+// POI is a point of interest, buildings imported as polygons are stored by their centroid
+type POI struct {
+	POIID    int         `gorm:"primaryKey;autoIncrement;column:poi_id"`
+	OSMID    *string     `gorm:"column:osm_id;uniqueIndex:idx_pois_osm_id"` // nil when the extract has no ids
+	Category string      `gorm:"not null;index:idx_pois_category"`
+	Name     string      `gorm:"column:name"`
+	Geom     georm.Point `gorm:"type:geometry(Point,4326);index:idx_pois_geom,type:gist"`
+}
+
+func (POI) TableName() string {
+	return "pois"
+}
+
 func MapToDistinct(dto DistrictDTO) *District {
 	return &District{
 		DistrictID: int(dto.Properties.Osm_relation_id),
@@ -132,12 +228,30 @@ func NewHeatpMap() *HeatMap {
 type CachedHeatMap struct {
 	HeatMapID int     `gorm:"column:heatmap_id;primaryKey;autoIncrement;-><-:create"`
 	HeatMap   HeatMap `gorm:"column:heatmap_data;type:json"`
+	// set for snapshots saved by name, named snapshots are never pruned
+	Name *string `gorm:"column:name;uniqueIndex:idx_cached_heatmaps_name"`
+	// normalized problem filter, "" for the heatmap of every problem
+	FilterKey string `gorm:"column:filter_key;not null;default:'';index:idx_cached_heatmaps_filter"`
+	// problems data version the heatmap was built from
+	Version   int64     `gorm:"column:data_version;not null;default:0"`
+	CreatedAt time.Time `gorm:"autoCreateTime"`
 }
 
 func (CachedHeatMap) TableName() string {
 	return "cached_heatmaps"
 }
 
+// DataVersion counts changes of a table, bumped by a trigger on every write statement
+type DataVersion struct {
+	Name      string    `gorm:"primaryKey"`
+	Version   int64     `gorm:"not null;default:0"`
+	UpdatedAt time.Time `gorm:"not null"`
+}
+
+func (DataVersion) TableName() string {
+	return "data_versions"
+}
+
 type HeatPoint struct {
 	Category int   `json:"category"`
 	Point    Point `json:"point"`
@@ -149,6 +263,8 @@ type Point struct {
 	Lon        float64 `json:"lon"`
 	Lat        float64 `json:"lat"`
 	Importance float64 `json:"importance"`
+	// 1 for point problems, grows with length or area of lines and polygons
+	Weight float64 `json:"weight"`
 }
 
 // HEATMAP VALUER/SCANNER
@@ -165,6 +281,68 @@ func (h *HeatMap) Scan(value interface{}) error {
 	return json.Unmarshal(bytes, h)
 }
 
+// EVENT VALUER/SCANNER
+func (e ProblemEvent) Value() (driver.Value, error) {
+	return json.Marshal(e)
+}
+
+func (e *ProblemEvent) Scan(value interface{}) error {
+	bytes, ok := value.([]byte)
+	if !ok {
+		return fmt.Errorf("expected []byte, got %T", value)
+	}
+
+	return json.Unmarshal(bytes, e)
+}
+
+func (h HandlerNames) Value() (driver.Value, error) {
+	if h == nil {
+		h = HandlerNames{}
+	}
+	return json.Marshal([]string(h))
+}
+
+func (h *HandlerNames) Scan(value interface{}) error {
+	bytes, ok := value.([]byte)
+	if !ok {
+		return fmt.Errorf("expected []byte, got %T", value)
+	}
+
+	return json.Unmarshal(bytes, (*[]string)(h))
+}
+
+func (l StringList) Value() (driver.Value, error) {
+	if l == nil {
+		l = StringList{}
+	}
+	return json.Marshal([]string(l))
+}
+
+func (l *StringList) Scan(value interface{}) error {
+	bytes, ok := value.([]byte)
+	if !ok {
+		return fmt.Errorf("expected []byte, got %T", value)
+	}
+
+	return json.Unmarshal(bytes, (*[]string)(l))
+}
+
+func (l IntList) Value() (driver.Value, error) {
+	if l == nil {
+		l = IntList{}
+	}
+	return json.Marshal([]int(l))
+}
+
+func (l *IntList) Scan(value interface{}) error {
+	bytes, ok := value.([]byte)
+	if !ok {
+		return fmt.Errorf("expected []byte, got %T", value)
+	}
+
+	return json.Unmarshal(bytes, (*[]int)(l))
+}
+
 type BreefAIResponse struct {
 	DistrictID int    `json:"district_id"`
 	Breef      string `json:"breef_answer"`
@@ -193,3 +371,265 @@ var ProblemTypeMap = map[int]string{
 	3: "Гос.сервис",
 	4: "Прочее",
 }
+
+// MAP QUERY ENTITIES
+type BBox struct {
+	MinLon float64
+	MinLat float64
+	MaxLon float64
+	MaxLat float64
+}
+
+// ParseBBox parses "minLon,minLat,maxLon,maxLat"
+func ParseBBox(raw string) (BBox, error) {
+	parts := strings.Split(raw, ",")
+	if len(parts) != 4 {
+		return BBox{}, fmt.Errorf("bbox must be minLon,minLat,maxLon,maxLat")
+	}
+
+	var coords [4]float64
+	for i, part := range parts {
+		v, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return BBox{}, fmt.Errorf("invalid bbox value %q", part)
+		}
+		coords[i] = v
+	}
+
+	return BBox{
+		MinLon: coords[0],
+		MinLat: coords[1],
+		MaxLon: coords[2],
+		MaxLat: coords[3],
+	}, nil
+}
+
+func (b BBox) Validate() error {
+	if b.MinLon >= b.MaxLon || b.MinLat >= b.MaxLat {
+		return fmt.Errorf("invalid bbox: min corner must be below max corner")
+	}
+	if b.MinLon < -180 || b.MaxLon > 180 || b.MinLat < -90 || b.MaxLat > 90 {
+		return fmt.Errorf("invalid bbox: coordinates out of range")
+	}
+	return nil
+}
+
+// EVENT ENTITIES
+const (
+	ProblemStatusCreated    = "created"
+	ProblemStatusInProgress = "in_progress"
+	ProblemStatusSolved     = "solved"
+	ProblemStatusStale      = "stale"
+)
+
+const (
+	EventProblemCreated       = "problem.created"
+	EventProblemUpdated       = "problem.updated"
+	EventProblemStatusChanged = "problem.status_changed"
+	EventProblemDeleted       = "problem.deleted"
+)
+
+// ProblemEvent is a change of one problem as pushed to live subscribers
+type ProblemEvent struct {
+	ID             int64     `json:"id"`
+	Type           string    `json:"type"`
+	ProblemID      int       `json:"problem_id"`
+	DistrictID     int       `json:"district_id"`
+	DistrictIDs    []int     `json:"district_ids,omitempty"`
+	TypeID         int       `json:"type_id"`
+	Name           string    `json:"name"`
+	Status         string    `json:"status"`
+	PreviousStatus string    `json:"previous_status,omitempty"`
+	Importance     float64   `json:"importance"`
+	Lon            float64   `json:"lon"`
+	Lat            float64   `json:"lat"`
+	OccurredAt     time.Time `json:"occurred_at"`
+}
+
+// NewProblemEvent describes the state of a problem, lines and polygons by their centroid
+func NewProblemEvent(eventType string, p *Problem, districtIDs []int) ProblemEvent {
+	event := ProblemEvent{
+		Type:        eventType,
+		ProblemID:   p.ProblemID,
+		DistrictIDs: districtIDs,
+		TypeID:      p.TypeId,
+		Name:        p.Name,
+		Status:      p.Status,
+		Importance:  p.Importance,
+		OccurredAt:  time.Now(),
+	}
+
+	if p.DistrictID != nil {
+		event.DistrictID = *p.DistrictID
+	}
+
+	if p.Geom.Geom != nil {
+		if centroid, err := xy.Centroid(p.Geom.Geom); err == nil {
+			event.Lon, event.Lat = centroid.X(), centroid.Y()
+		}
+	}
+
+	return event
+}
+
+// HandlerNames lists the outbox handlers an event was delivered to
+type HandlerNames []string
+
+// OutboxEvent is a domain event stored in the transaction of the change that raised it,
+// the dispatcher delivers it to every handler at least once
+type OutboxEvent struct {
+	EventID   int64        `gorm:"primaryKey;autoIncrement;-><-:create" json:"event_id"`
+	Type      string       `gorm:"not null" json:"type"`
+	ProblemID int          `gorm:"not null;index:idx_outbox_events_problem" json:"problem_id"`
+	Payload   ProblemEvent `gorm:"type:json;not null" json:"payload"`
+	Delivered HandlerNames `gorm:"type:json;not null;default:'[]'" json:"delivered"`
+	Attempts  int          `gorm:"not null;default:0" json:"attempts"`
+	LastError string       `json:"last_error,omitempty"`
+	// also the claim lease, a dispatcher pushes it forward while it works on the event
+	NextAttemptAt time.Time  `gorm:"not null;index:idx_outbox_events_pending,where:dispatched_at IS NULL AND failed_at IS NULL" json:"next_attempt_at"`
+	DispatchedAt  *time.Time `json:"dispatched_at,omitempty"`
+	// set when the event ran out of attempts
+	FailedAt  *time.Time `json:"failed_at,omitempty"`
+	CreatedAt time.Time  `gorm:"autoCreateTime" json:"created_at"`
+}
+
+func (OutboxEvent) TableName() string {
+	return "outbox_events"
+}
+
+// WEBHOOK ENTITIES
+const (
+	WebhookDeliveryPending   = "pending"
+	WebhookDeliveryDelivered = "delivered"
+	// ran out of attempts, kept until an admin retries it
+	WebhookDeliveryDead = "dead"
+)
+
+type StringList []string
+
+type IntList []int
+
+// WebhookSubscription asks for problem events to be POSTed to a partner URL,
+// empty filters match everything
+type WebhookSubscription struct {
+	WebhookID int    `gorm:"primaryKey;autoIncrement" json:"webhook_id"`
+	URL       string `gorm:"not null" json:"url"`
+	// signs the payloads, only returned when the webhook is created
+	Secret      string     `gorm:"not null" json:"secret,omitempty"`
+	EventTypes  StringList `gorm:"type:json;not null;default:'[]'" json:"event_types"`
+	DistrictIDs IntList    `gorm:"type:json;not null;default:'[]'" json:"district_ids"`
+	TypeIDs     IntList    `gorm:"type:json;not null;default:'[]'" json:"type_ids"`
+	Active      bool       `gorm:"not null" json:"active"`
+	CreatedAt   time.Time  `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt   time.Time  `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+func (WebhookSubscription) TableName() string {
+	return "webhook_subscriptions"
+}
+
+// WebhookDelivery is one event for one webhook, retried with backoff until it succeeds or dies
+type WebhookDelivery struct {
+	DeliveryID int64        `gorm:"primaryKey;autoIncrement;-><-:create" json:"delivery_id"`
+	WebhookID  int          `gorm:"not null;uniqueIndex:idx_webhook_deliveries_event" json:"webhook_id"`
+	EventID    int64        `gorm:"not null;uniqueIndex:idx_webhook_deliveries_event" json:"event_id"`
+	EventType  string       `gorm:"not null" json:"event_type"`
+	Payload    ProblemEvent `gorm:"type:json;not null" json:"payload"`
+	Status     string       `gorm:"not null;index:idx_webhook_deliveries_status" json:"status"`
+	Attempts   int          `gorm:"not null;default:0" json:"attempts"`
+	// status code of the last attempt, 0 when the request did not get a response
+	ResponseStatus int    `gorm:"not null;default:0" json:"response_status"`
+	LastError      string `json:"last_error,omitempty"`
+	// also the claim lease, like the outbox
+	NextAttemptAt time.Time  `gorm:"not null;index:idx_webhook_deliveries_pending,where:status = 'pending'" json:"next_attempt_at"`
+	DeliveredAt   *time.Time `json:"delivered_at,omitempty"`
+	CreatedAt     time.Time  `gorm:"autoCreateTime" json:"created_at"`
+}
+
+func (WebhookDelivery) TableName() string {
+	return "webhook_deliveries"
+}
+
+// JOB ENTITIES
+const (
+	JobTriggerSchedule = "schedule"
+	JobTriggerManual   = "manual"
+
+	JobStatusRunning   = "running"
+	JobStatusSucceeded = "succeeded"
+	JobStatusFailed    = "failed"
+)
+
+// JobRun is one execution of a background job on one replica
+type JobRun struct {
+	RunID      int        `gorm:"primaryKey;autoIncrement" json:"run_id"`
+	Job        string     `gorm:"not null;index:idx_job_runs_job" json:"job"`
+	Trigger    string     `gorm:"not null" json:"trigger"`
+	Instance   string     `gorm:"not null;default:''" json:"instance"`
+	Status     string     `gorm:"not null" json:"status"`
+	Output     string     `json:"output,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	StartedAt  time.Time  `gorm:"not null" json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+func (JobRun) TableName() string {
+	return "job_runs"
+}
+
+// GEOJSON ENTITIES
+type GeoJSONFeature struct {
+	Type       string          `json:"type"`
+	Geometry   json.RawMessage `json:"geometry"`
+	Properties interface{}     `json:"properties"`
+}
+
+type GeoJSONFeatureCollection struct {
+	Type     string           `json:"type"`
+	Features []GeoJSONFeature `json:"features"`
+}
+
+func NewFeature(geometry string, properties interface{}) GeoJSONFeature {
+	return GeoJSONFeature{
+		Type:       "Feature",
+		Geometry:   json.RawMessage(geometry),
+		Properties: properties,
+	}
+}
+
+func NewFeatureCollection(features []GeoJSONFeature) *GeoJSONFeatureCollection {
+	return &GeoJSONFeatureCollection{
+		Type:     "FeatureCollection",
+		Features: features,
+	}
+}
+
+// HeatCellProperties are the properties of one aggregated heatmap cell
+type HeatCellProperties struct {
+	ProblemCount  int         `json:"problem_count"`
+	ImportanceSum float64     `json:"importance_sum"`
+	Categories    map[int]int `json:"categories"`
+}
+
+// DistrictProperties are the properties of a district geojson feature
+type DistrictProperties struct {
+	DistrictID   int     `json:"district_id"`
+	Name         string  `json:"name"`
+	NameRU       string  `json:"name_ru"`
+	NameENG      string  `json:"name_eng"`
+	Reputation   float64 `json:"reputation"`
+	ProblemCount int     `json:"problem_count"`
+	OpenCount    int     `json:"open_count"`
+}
+
+// AlmatyEnvelope is the default city envelope, reports outside it are rejected
+var AlmatyEnvelope = BBox{
+	MinLon: 76.60,
+	MinLat: 43.05,
+	MaxLon: 77.30,
+	MaxLat: 43.50,
+}
+
+func (b BBox) Contains(lon, lat float64) bool {
+	return lon >= b.MinLon && lon <= b.MaxLon && lat >= b.MinLat && lat <= b.MaxLat
+}
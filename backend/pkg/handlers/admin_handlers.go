@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rwrrioe/geomap/backend/pkg/service"
+)
+
+// RequireAdmin accepts requests with "Authorization: Bearer <token>",
+// without a configured token the admin endpoints are disabled
+func (h *HTTPHandlers) RequireAdmin(token string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if token == "" {
+			respondError(c, fmt.Errorf("admin endpoints are disabled"), http.StatusForbidden)
+			return
+		}
+
+		given, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			respondError(c, fmt.Errorf("admin token required"), http.StatusUnauthorized)
+			return
+		}
+
+		c.Next()
+	}
+}
+
+/*
+pattern: /admin/jobs
+method:  GET
+info:	 Authorization: Bearer <ADMIN_TOKEN>
+
+succeed:
+
+	status code: 200 OK
+	response body: json represents background jobs with schedule, next run and last run
+
+failed:
+
+	status code: 500, 401, 403 ...
+	response body: json with error, time
+*/
+func (h *HTTPHandlers) ListJobs(c *gin.Context) {
+	jobs, err := h.Scheduler.ListJobs(c)
+	if err != nil {
+		respondError(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, jobs)
+}
+
+/*
+pattern: /admin/jobs/:job/run
+method:  POST
+info:	 parameters from path, Authorization: Bearer <ADMIN_TOKEN>, the job runs in the background
+
+succeed:
+
+	status code: 202 accepted
+	response body: json represents the started run, poll /admin/jobs/:job/runs for the result
+
+failed:
+
+	status code: 500, 409 already running on some replica, 404, 401, 403 ...
+	response body: json with error, time
+*/
+func (h *HTTPHandlers) TriggerJob(c *gin.Context) {
+	run, err := h.Scheduler.Trigger(c, c.Param("job"))
+	if errors.Is(err, service.ErrJobNotFound) {
+		respondError(c, err, http.StatusNotFound)
+		return
+	}
+
+	if errors.Is(err, service.ErrJobRunning) {
+		respondError(c, err, http.StatusConflict)
+		return
+	}
+
+	if err != nil {
+		respondError(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, run)
+}
+
+/*
+pattern: /admin/jobs/:job/runs?limit=20
+method:  GET
+info:	 parameters from path, Authorization: Bearer <ADMIN_TOKEN>
+
+succeed:
+
+	status code: 200 OK
+	response body: json represents runs of the job newest first
+
+failed:
+
+	status code: 500, 404, 400, 401, 403 ...
+	response body: json with error, time
+*/
+func (h *HTTPHandlers) ListJobRuns(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "0"))
+	if err != nil {
+		respondError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	runs, err := h.Scheduler.ListJobRuns(c, c.Param("job"), limit)
+	if errors.Is(err, service.ErrJobNotFound) {
+		respondError(c, err, http.StatusNotFound)
+		return
+	}
+
+	if err != nil {
+		respondError(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, runs)
+}
@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rwrrioe/geomap/backend/pkg/service"
+)
+
+/*
+pattern: /analysis/corridor
+method:  POST
+info:	 json body with geojson "line" or encoded "polyline", "buffer" in meters,
+optional "segment_length" in meters and "crs" of the line
+
+succeed:
+
+	status code: 200 OK
+	response body: json represents problems along the route and per segment stats
+
+failed:
+
+	status code: 500, 400 ...
+	response body: json with error, time
+*/
+func (h *HTTPHandlers) GetCorridorProblems(c *gin.Context) {
+	var req corridorRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	srid, err := h.CRSService.ParseSRID(c, req.CRS)
+	if err != nil {
+		respondError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	result, err := h.CorridorService.ProblemsAlongRoute(c, service.CorridorQuery{
+		Line:          string(req.Line),
+		Polyline:      req.Polyline,
+		SRID:          srid,
+		Buffer:        req.Buffer,
+		SegmentLength: req.SegmentLength,
+	})
+	if err != nil {
+		respondError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+/*
+pattern: /analysis/area
+method:  POST
+info:	 json body with geojson "polygon", optional "crs" of the polygon and "narrative": true for an AI summary
+
+succeed:
+
+	status code: 200 OK
+	response body: json represents problem counts, solved counts and average importance per type inside the polygon
+
+failed:
+
+	status code: 500, 400 ...
+	response body: json with error, time
+*/
+func (h *HTTPHandlers) GetAreaAnalysis(c *gin.Context) {
+	var req areaRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	srid, err := h.CRSService.ParseSRID(c, req.CRS)
+	if err != nil {
+		respondError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	analysis, err := h.AreaService.Analyze(c, service.AreaQuery{
+		Polygon:   string(req.Polygon),
+		SRID:      srid,
+		Narrative: req.Narrative,
+	})
+	if err != nil {
+		respondError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	c.JSON(http.StatusOK, analysis)
+}
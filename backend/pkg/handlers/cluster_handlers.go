@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rwrrioe/geomap/backend/pkg/entities"
+)
+
+/*
+pattern: /heatmap/clusters?bbox=minLon,minLat,maxLon,maxLat&zoom=12&radius=60&crs=EPSG:3857
+method:  GET
+info:	 query params, radius is in screen pixels, radius and crs of bbox are optional
+
+succeed:
+
+	status code: 200 OK
+	response body: json represents problem clusters
+
+failed:
+
+	status code: 500, 400 ...
+	response body: json with error, time
+*/
+func (h *HTTPHandlers) ListClusters(c *gin.Context) {
+	bbox, err := entities.ParseBBox(c.Query("bbox"))
+	if err != nil {
+		respondError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	srid, err := h.CRSService.ParseSRID(c, c.Query("crs"))
+	if err != nil {
+		respondError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	bbox, err = h.CRSService.ToWGS84BBox(c, bbox, srid)
+	if err != nil {
+		respondError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	zoom, err := strconv.Atoi(c.Query("zoom"))
+	if err != nil {
+		respondError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	radius, err := strconv.Atoi(c.DefaultQuery("radius", "0"))
+	if err != nil {
+		respondError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	clusters, err := h.HeatMapService.ListClusters(c, bbox, zoom, radius)
+	if err != nil {
+		respondError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	c.JSON(http.StatusOK, clusters)
+}
@@ -0,0 +1,171 @@
+package handlers
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	encodingBrotli = "br"
+	encodingGzip   = "gzip"
+
+	// brotli above 5 costs far more cpu than it saves on json
+	brotliLevel = 5
+)
+
+// content types worth compressing, images and archives are compressed already
+var compressibleTypes = []string{
+	"application/json",
+	"application/geo+json",
+	"application/vnd.geomap.heatmap",
+	"application/vnd.mapbox-vector-tile",
+	"text/",
+}
+
+var (
+	gzipWriters = sync.Pool{New: func() interface{} {
+		w, _ := gzip.NewWriterLevel(io.Discard, gzip.DefaultCompression)
+		return w
+	}}
+	brotliWriters = sync.Pool{New: func() interface{} {
+		return brotli.NewWriterLevel(io.Discard, brotliLevel)
+	}}
+)
+
+// negotiateEncoding picks br over gzip from an Accept-Encoding header, "" for identity
+func negotiateEncoding(header string) string {
+	accepted := map[string]bool{}
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			q, _ = strconv.ParseFloat(v, 64)
+		}
+		accepted[strings.ToLower(strings.TrimSpace(name))] = q > 0
+	}
+
+	switch {
+	case accepted[encodingBrotli]:
+		return encodingBrotli
+	case accepted[encodingGzip]:
+		return encodingGzip
+	default:
+		return ""
+	}
+}
+
+func isCompressible(contentType string) bool {
+	// browsers handle compressed event streams but buffering proxies delay them
+	if strings.HasPrefix(contentType, "text/event-stream") {
+		return false
+	}
+
+	for _, t := range compressibleTypes {
+		if strings.HasPrefix(contentType, t) {
+			return true
+		}
+	}
+	return false
+}
+
+type flushWriter interface {
+	io.WriteCloser
+	Flush() error
+}
+
+// compressWriter decides on the first write, once the handler has set the content type
+type compressWriter struct {
+	gin.ResponseWriter
+	encoding string
+	decided  bool
+	w        flushWriter
+}
+
+func (cw *compressWriter) decide() {
+	cw.decided = true
+
+	h := cw.Header()
+	status := cw.Status()
+	if status == http.StatusNoContent || status == http.StatusNotModified ||
+		h.Get("Content-Encoding") != "" || !isCompressible(h.Get("Content-Type")) {
+		return
+	}
+
+	h.Set("Content-Encoding", cw.encoding)
+	h.Del("Content-Length")
+
+	switch cw.encoding {
+	case encodingBrotli:
+		w := brotliWriters.Get().(*brotli.Writer)
+		w.Reset(cw.ResponseWriter)
+		cw.w = w
+	case encodingGzip:
+		w := gzipWriters.Get().(*gzip.Writer)
+		w.Reset(cw.ResponseWriter)
+		cw.w = w
+	}
+}
+
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	if !cw.decided {
+		cw.decide()
+	}
+
+	if cw.w == nil {
+		return cw.ResponseWriter.Write(b)
+	}
+	return cw.w.Write(b)
+}
+
+func (cw *compressWriter) WriteString(s string) (int, error) {
+	return cw.Write([]byte(s))
+}
+
+// Flush pushes compressed bytes through so streamed responses are not held back
+func (cw *compressWriter) Flush() {
+	if cw.w != nil {
+		cw.w.Flush()
+	}
+	cw.ResponseWriter.Flush()
+}
+
+func (cw *compressWriter) close() {
+	if cw.w == nil {
+		return
+	}
+
+	cw.w.Close()
+	switch w := cw.w.(type) {
+	case *brotli.Writer:
+		brotliWriters.Put(w)
+	case *gzip.Writer:
+		gzipWriters.Put(w)
+	}
+	cw.w = nil
+}
+
+// Compress encodes compressible responses with brotli or gzip as the client accepts
+func (h *HTTPHandlers) Compress() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Vary", "Accept-Encoding")
+
+		encoding := negotiateEncoding(c.GetHeader("Accept-Encoding"))
+		if encoding == "" || c.Request.Method == http.MethodHead {
+			c.Next()
+			return
+		}
+
+		cw := &compressWriter{ResponseWriter: c.Writer, encoding: encoding}
+		c.Writer = cw
+		defer cw.close()
+
+		c.Next()
+	}
+}
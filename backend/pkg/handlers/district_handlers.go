@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+/*
+pattern: /districts?tolerance=0.0005&lang=ru
+method:  GET
+info:	 optional query params, tolerance in degrees, lang is "ru" or "en"
+
+succeed:
+
+	status code: 200 OK
+	response body: geojson represents districts with problem counts
+
+failed:
+
+	status code: 500, 400 ...
+	response body: json with error, time
+*/
+func (h *HTTPHandlers) ListDistricts(c *gin.Context) {
+	tolerance, err := strconv.ParseFloat(c.DefaultQuery("tolerance", "0"), 64)
+	if err != nil {
+		respondError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	districts, err := h.DistrictService.ListDistricts(c, tolerance, c.Query("lang"))
+	if err != nil {
+		respondError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	c.JSON(http.StatusOK, districts)
+}
+
+/*
+pattern: /districts/:districtID?tolerance=0.0005&lang=ru
+method:  GET
+info:	 parameters from path + optional query params
+
+succeed:
+
+	status code: 200 OK
+	response body: geojson feature represents district
+
+failed:
+
+	status code: 500, 404, 400 ...
+	response body: json with error, time
+*/
+func (h *HTTPHandlers) GetDistrict(c *gin.Context) {
+	var distID districtID
+
+	if err := c.ShouldBindUri(&distID); err != nil {
+		respondError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	tolerance, err := strconv.ParseFloat(c.DefaultQuery("tolerance", "0"), 64)
+	if err != nil {
+		respondError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	district, err := h.DistrictService.GetDistrict(c, distID.DistrictID, tolerance, c.Query("lang"))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		respondError(c, err, http.StatusNotFound)
+		return
+	}
+
+	if err != nil {
+		respondError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	c.JSON(http.StatusOK, district)
+}
+
+/*
+pattern: /districts/:districtID/neighbours?band=500
+method:  GET
+info:	 parameters from path, band is the optional width in meters of the strip along shared borders
+
+succeed:
+
+	status code: 200 OK
+	response body: json represents per type stats against the neighbour average and border problem counts
+
+failed:
+
+	status code: 500, 404, 400 ...
+	response body: json with error, time
+*/
+func (h *HTTPHandlers) CompareDistrictNeighbours(c *gin.Context) {
+	var distID districtID
+
+	if err := c.ShouldBindUri(&distID); err != nil {
+		respondError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	band, err := strconv.ParseFloat(c.DefaultQuery("band", "0"), 64)
+	if err != nil {
+		respondError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	comparison, err := h.DistrictService.CompareWithNeighbours(c, distID.DistrictID, band)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		respondError(c, err, http.StatusNotFound)
+		return
+	}
+
+	if err != nil {
+		respondError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	c.JSON(http.StatusOK, comparison)
+}
@@ -1,14 +1,83 @@
 package handlers
 
 import (
+	"encoding/json"
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rwrrioe/geomap/backend/pkg/entities"
+	"github.com/rwrrioe/geomap/backend/pkg/repository"
 )
 
 type districtID struct {
 	DistrictID int `uri:"districtID" binding:"required"`
 }
 
+type snapshotID struct {
+	SnapshotID int `uri:"snapshotID" binding:"required"`
+}
+
+type snapshotRequest struct {
+	Name string `json:"name" binding:"required"`
+}
+
+type corridorRequest struct {
+	Line          json.RawMessage `json:"line"`
+	Polyline      string          `json:"polyline"`
+	Buffer        float64         `json:"buffer" binding:"required"`
+	SegmentLength float64         `json:"segment_length"`
+	CRS           string          `json:"crs"`
+}
+
+type statusRequest struct {
+	Status string `json:"status" binding:"required"`
+}
+
+type problemUpdateRequest struct {
+	Name        *string `json:"problem_name"`
+	Description *string `json:"problem_desc"`
+	TypeID      *int    `json:"problem_typeid"`
+	DistrictID  *int    `json:"district_id"`
+}
+
+type webhookRequest struct {
+	URL         string   `json:"url" binding:"required"`
+	Secret      string   `json:"secret"`
+	EventTypes  []string `json:"event_types"`
+	DistrictIDs []int    `json:"district_ids"`
+	TypeIDs     []int    `json:"type_ids"`
+	Active      *bool    `json:"active"`
+}
+
+func (r webhookRequest) webhook() *entities.WebhookSubscription {
+	active := r.Active == nil || *r.Active
+	return &entities.WebhookSubscription{
+		URL:         r.URL,
+		Secret:      r.Secret,
+		EventTypes:  r.EventTypes,
+		DistrictIDs: r.DistrictIDs,
+		TypeIDs:     r.TypeIDs,
+		Active:      active,
+	}
+}
+
+type areaRequest struct {
+	Polygon   json.RawMessage `json:"polygon" binding:"required"`
+	CRS       string          `json:"crs"`
+	Narrative bool            `json:"narrative"`
+}
+
+// heatmapDTO carries the data version so clients can tell whether their copy is current
+type heatmapDTO struct {
+	entities.HeatMap
+	Filter  string    `json:"filter,omitempty"`
+	Version int64     `json:"version"`
+	BuiltAt time.Time `json:"built_at"`
+}
+
 type errDTO struct {
 	Message string
 	Time    time.Time
@@ -27,3 +96,86 @@ func validateCityID(id int) error {
 	}
 	return nil
 }
+
+// parseTime accepts a date (2006-01-02) or an RFC3339 timestamp
+func parseTime(raw string) (time.Time, error) {
+	if t, err := time.Parse("2006-01-02", raw); err == nil {
+		return t, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q", raw)
+	}
+	return t, nil
+}
+
+// parseIDList reads a comma separated list of ids from the query param
+func parseIDList(c *gin.Context, param string) ([]int, error) {
+	raw := c.Query(param)
+	if raw == "" {
+		return nil, nil
+	}
+
+	var ids []int
+	for _, part := range strings.Split(raw, ",") {
+		id, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s %q", param, part)
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
+
+// parseProblemFilter reads type_id, status, district_id (comma separated), from, to and min_importance query params
+func parseProblemFilter(c *gin.Context) (repository.ProblemFilter, error) {
+	var filter repository.ProblemFilter
+
+	if raw := c.Query("type_id"); raw != "" {
+		typeID, err := strconv.Atoi(raw)
+		if err != nil {
+			return filter, fmt.Errorf("invalid type_id %q", raw)
+		}
+		filter.TypeID = &typeID
+	}
+
+	filter.Status = c.Query("status")
+
+	districtIDs, err := parseIDList(c, "district_id")
+	if err != nil {
+		return filter, err
+	}
+	filter.DistrictIDs = districtIDs
+
+	if raw := c.Query("from"); raw != "" {
+		from, err := parseTime(raw)
+		if err != nil {
+			return filter, err
+		}
+		filter.From = &from
+	}
+
+	if raw := c.Query("to"); raw != "" {
+		to, err := parseTime(raw)
+		if err != nil {
+			return filter, err
+		}
+		filter.To = &to
+	}
+
+	if raw := c.Query("min_importance"); raw != "" {
+		minImportance, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return filter, fmt.Errorf("invalid min_importance %q", raw)
+		}
+		filter.MinImportance = &minImportance
+	}
+
+	if filter.From != nil && filter.To != nil && !filter.From.Before(*filter.To) {
+		return filter, fmt.Errorf("from must be before to")
+	}
+
+	return filter, nil
+}
@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+/*
+pattern: /export?format=csv&type_id=1&status=created&district_id=1,2&from=2025-01-01&to=2025-02-01
+method:  GET
+info:	 format is csv, kml, gpx or shp (zipped), other query params filter problems
+
+succeed:
+
+	status code: 200 OK
+	response body: streamed file with problems
+
+failed:
+
+	status code: 500, 400 ...
+	response body: json with error, time (only before streaming started)
+*/
+func (h *HTTPHandlers) ExportProblems(c *gin.Context) {
+	format, err := h.ExportService.Format(c.Query("format"))
+	if err != nil {
+		respondError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	filter, err := parseProblemFilter(c)
+	if err != nil {
+		respondError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	filename := fmt.Sprintf("problems-%s.%s", time.Now().Format("20060102-150405"), format.Extension)
+	c.Header("Content-Type", format.ContentType)
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	c.Status(http.StatusOK)
+
+	if err := h.ExportService.Export(c, c.Query("format"), filter, c.Writer); err != nil {
+		// headers are already sent, only log and cut the stream
+		c.Error(err)
+		c.Abort()
+	}
+}
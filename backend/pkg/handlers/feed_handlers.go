@@ -0,0 +1,328 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/rwrrioe/geomap/backend/pkg/entities"
+	"github.com/rwrrioe/geomap/backend/pkg/repository"
+	"github.com/rwrrioe/geomap/backend/pkg/service"
+	"gorm.io/gorm"
+)
+
+const (
+	feedHeartbeat = 25 * time.Second
+	feedRetry     = 3000 // ms before an EventSource reconnects
+	// sent instead of a replay when the last event id is too old, the client reloads its problems
+	feedResetEvent = "reset"
+)
+
+var feedUpgrader = websocket.Upgrader{
+	// the feed is read only and carries what GET /heatmap already exposes
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+func parseEventFilter(c *gin.Context) (service.EventFilter, error) {
+	var filter service.EventFilter
+
+	districtIDs, err := parseIDList(c, "district_id")
+	if err != nil {
+		return filter, err
+	}
+
+	typeIDs, err := parseIDList(c, "type_id")
+	if err != nil {
+		return filter, err
+	}
+
+	filter.DistrictIDs, filter.TypeIDs = districtIDs, typeIDs
+	if raw := c.Query("events"); raw != "" {
+		filter.Types = strings.Split(raw, ",")
+	}
+
+	return filter, nil
+}
+
+// lastEventID comes from the EventSource reconnect header or from the query for WebSocket clients
+func lastEventID(c *gin.Context) (int64, error) {
+	raw := c.GetHeader("Last-Event-ID")
+	if raw == "" {
+		raw = c.Query("last_event_id")
+	}
+
+	if raw == "" {
+		return 0, nil
+	}
+
+	id, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid last event id %q", raw)
+	}
+	return id, nil
+}
+
+/*
+pattern: /problems/feed?district_id=1,2&type_id=3&events=problem.created,problem.status_changed&last_event_id=42
+method:  GET
+info:	 server-sent events, or a WebSocket when the request asks for an upgrade; event ids are outbox ids, Last-Event-ID header or last_event_id resumes after that event on any replica
+
+succeed:
+
+	status code: 200 OK, 101 switching protocols for WebSocket
+	response body: stream of problem events, a "reset" event when events since last_event_id are no longer available
+
+failed:
+
+	status code: 500, 400 ...
+	response body: json with error, time
+*/
+func (h *HTTPHandlers) StreamProblems(c *gin.Context) {
+	filter, err := parseEventFilter(c)
+	if err != nil {
+		respondError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	lastID, err := lastEventID(c)
+	if err != nil {
+		respondError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	sub, replay, complete, err := h.Events.Subscribe(c, filter, lastID)
+	if err != nil {
+		respondError(c, err, http.StatusInternalServerError)
+		return
+	}
+	defer h.Events.Unsubscribe(sub)
+
+	if websocket.IsWebSocketUpgrade(c.Request) {
+		h.streamWebSocket(c, sub, replay, complete)
+		return
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	fmt.Fprintf(c.Writer, "retry: %d\n\n", feedRetry)
+	if !complete {
+		fmt.Fprintf(c.Writer, "event: %s\ndata: {}\n\n", feedResetEvent)
+	}
+	for _, e := range replay {
+		writeSSE(c, e)
+	}
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(feedHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(c.Writer, ": ping\n\n")
+		case e, ok := <-sub.Events():
+			if !ok {
+				// dropped for falling behind, the client resumes from its last id
+				return
+			}
+			writeSSE(c, e)
+		}
+		c.Writer.Flush()
+	}
+}
+
+func writeSSE(c *gin.Context, e entities.ProblemEvent) {
+	data, _ := json.Marshal(e)
+	fmt.Fprintf(c.Writer, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data)
+}
+
+func (h *HTTPHandlers) streamWebSocket(c *gin.Context, sub *service.Subscription, replay []entities.ProblemEvent, complete bool) {
+	conn, err := feedUpgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// the upgrader already answered with an error status
+		return
+	}
+	defer conn.Close()
+
+	// clients only send control frames, reading detects the close
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	if !complete {
+		if err := conn.WriteJSON(gin.H{"type": feedResetEvent}); err != nil {
+			return
+		}
+	}
+	for _, e := range replay {
+		if err := conn.WriteJSON(e); err != nil {
+			return
+		}
+	}
+
+	heartbeat := time.NewTicker(feedHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		var err error
+		select {
+		case <-closed:
+			return
+		case <-heartbeat.C:
+			err = conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(10*time.Second))
+		case e, ok := <-sub.Events():
+			if !ok {
+				conn.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "subscriber fell behind"),
+					time.Now().Add(time.Second))
+				return
+			}
+			err = conn.WriteJSON(e)
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+/*
+pattern: /admin/problems/:problemID/status
+method:  PUT
+info:	 json body with "status": created, in_progress or solved, Authorization: Bearer <ADMIN_TOKEN>
+
+succeed:
+
+	status code: 200 OK
+	response body: json represents the updated problem, live feed subscribers get problem.status_changed
+
+failed:
+
+	status code: 500, 404, 400, 401, 403 ...
+	response body: json with error, time
+*/
+func (h *HTTPHandlers) UpdateProblemStatus(c *gin.Context) {
+	problemID, err := strconv.Atoi(c.Param("problemID"))
+	if err != nil {
+		respondError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	var req statusRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	problem, err := h.ProblemService.UpdateStatus(c, problemID, req.Status)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		respondError(c, err, http.StatusNotFound)
+		return
+	}
+
+	if err != nil {
+		respondError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	c.JSON(http.StatusOK, problem)
+}
+
+/*
+pattern: /admin/problems/:problemID
+method:  PATCH
+info:	 json body with any of problem_name, problem_desc, problem_typeid, district_id; a district takes the problem out of review and replaces the districts of a line or polygon; Authorization: Bearer <ADMIN_TOKEN>
+
+succeed:
+
+	status code: 200 OK
+	response body: json represents the updated problem, subscribers get problem.updated when something changed
+
+failed:
+
+	status code: 500, 404, 400, 401, 403 ...
+	response body: json with error, time
+*/
+func (h *HTTPHandlers) UpdateProblem(c *gin.Context) {
+	problemID, err := strconv.Atoi(c.Param("problemID"))
+	if err != nil {
+		respondError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	var req problemUpdateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	problem, err := h.ProblemService.UpdateProblem(c, problemID, repository.ProblemUpdate{
+		Name:        req.Name,
+		Description: req.Description,
+		TypeID:      req.TypeID,
+		DistrictID:  req.DistrictID,
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		respondError(c, err, http.StatusNotFound)
+		return
+	}
+
+	if err != nil {
+		respondError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	c.JSON(http.StatusOK, problem)
+}
+
+/*
+pattern: /admin/problems/:problemID
+method:  DELETE
+info:	 parameters from path, Authorization: Bearer <ADMIN_TOKEN>
+
+succeed:
+
+	status code: 204 no content, subscribers get problem.deleted
+
+failed:
+
+	status code: 500, 404, 400, 401, 403 ...
+	response body: json with error, time
+*/
+func (h *HTTPHandlers) DeleteProblem(c *gin.Context) {
+	problemID, err := strconv.Atoi(c.Param("problemID"))
+	if err != nil {
+		respondError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	err = h.ProblemService.DeleteProblem(c, problemID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		respondError(c, err, http.StatusNotFound)
+		return
+	}
+
+	if err != nil {
+		respondError(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+/*
+pattern: /geocode/reverse?lat=43.25&lon=76.94&crs=EPSG:4326
+method:  GET
+info:	 query params, crs is optional and applies to lat/lon as y/x
+
+succeed:
+
+	status code: 200 OK
+	response body: json represents nearest street address
+
+failed:
+
+	status code: 500, 404, 400 ...
+	response body: json with error, time
+*/
+func (h *HTTPHandlers) ReverseGeocode(c *gin.Context) {
+	lat, err := strconv.ParseFloat(c.Query("lat"), 64)
+	if err != nil {
+		respondError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	lon, err := strconv.ParseFloat(c.Query("lon"), 64)
+	if err != nil {
+		respondError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	srid, err := h.CRSService.ParseSRID(c, c.Query("crs"))
+	if err != nil {
+		respondError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	lon, lat, err = h.CRSService.ToWGS84Point(c, lon, lat, srid)
+	if err != nil {
+		respondError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	address, err := h.GeocodingService.Reverse(c, lat, lon)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		respondError(c, err, http.StatusNotFound)
+		return
+	}
+
+	if err != nil {
+		respondError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	c.JSON(http.StatusOK, address)
+}
+
+/*
+pattern: /geocode/search?q=абая 10&limit=10
+method:  GET
+info:	 query params, names are matched fuzzily in russian and kazakh
+
+succeed:
+
+	status code: 200 OK
+	response body: json represents matching addresses and places
+
+failed:
+
+	status code: 500, 400 ...
+	response body: json with error, time
+*/
+func (h *HTTPHandlers) SearchAddress(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "0"))
+	if err != nil {
+		respondError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	matches, err := h.GeocodingService.Search(c, c.Query("q"), limit)
+	if err != nil {
+		respondError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	c.JSON(http.StatusOK, matches)
+}
@@ -0,0 +1,364 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rwrrioe/geomap/backend/pkg/service"
+	"gorm.io/gorm"
+)
+
+func (h *HTTPHandlers) getAggregatedHeatmap(c *gin.Context) {
+	size, err := strconv.ParseFloat(c.DefaultQuery("size", "0"), 64)
+	if err != nil {
+		respondError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	filter, err := parseProblemFilter(c)
+	if err != nil {
+		respondError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	version, err := h.HeatMapService.DataVersion(c)
+	if err != nil {
+		respondError(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	variant := fmt.Sprintf("grid|%s|%s|%g", filter.Key(), c.Query("cell"), size)
+	if setValidators(c, versionETag(version.Version, variant), version.UpdatedAt) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	cells, err := h.HeatMapService.GetAggregatedHeatMap(c, c.Query("cell"), size, filter)
+	if err != nil {
+		respondError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	c.JSON(http.StatusOK, cells)
+}
+
+/*
+pattern: /heatmap/hotspots?type_id=1&from=2025-01-01&to=2025-02-01&cell_size=1000&value=count
+method:  GET
+info:	 optional query params, value is "count" or "importance", cell_size in meters
+
+succeed:
+
+	status code: 200 OK
+	response body: geojson with significant hot and cold cells (Getis-Ord Gi*)
+
+failed:
+
+	status code: 500, 400 ...
+	response body: json with error, time
+*/
+func (h *HTTPHandlers) GetHotspots(c *gin.Context) {
+	filter, err := parseProblemFilter(c)
+	if err != nil {
+		respondError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	cellSize, err := strconv.ParseFloat(c.DefaultQuery("cell_size", "0"), 64)
+	if err != nil {
+		respondError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	hotspots, err := h.HotspotService.DetectGeoJSON(c, service.HotspotQuery{
+		CellSize: cellSize,
+		Value:    c.Query("value"),
+		Filter:   filter,
+	})
+	if err != nil {
+		respondError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	c.JSON(http.StatusOK, hotspots)
+}
+
+/*
+pattern: /heatmap/frames?bucket=week&from=2025-01-01&to=2025-06-01&cumulative=true&output=cells&cell=hex&size=500
+method:  GET
+info:	 query params, from is required, bucket is day/week/month, output is points or cells, problem filters of /heatmap apply
+
+succeed:
+
+	status code: 200 OK
+	response body: json represents heatmap frames ordered by time
+
+failed:
+
+	status code: 500, 400 ...
+	response body: json with error, time
+*/
+func (h *HTTPHandlers) GetHeatmapFrames(c *gin.Context) {
+	filter, err := parseProblemFilter(c)
+	if err != nil {
+		respondError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	cumulative, err := strconv.ParseBool(c.DefaultQuery("cumulative", "false"))
+	if err != nil {
+		respondError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	size, err := strconv.ParseFloat(c.DefaultQuery("size", "0"), 64)
+	if err != nil {
+		respondError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	frames, err := h.HeatMapService.GetFrames(c, service.FrameQuery{
+		Bucket:     c.Query("bucket"),
+		Cumulative: cumulative,
+		Output:     c.Query("output"),
+		CellShape:  c.Query("cell"),
+		CellSize:   size,
+		Filter:     filter,
+	})
+	if err != nil {
+		respondError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	c.Header("X-Heatmap-Version", strconv.FormatInt(frames.Version, 10))
+	c.JSON(http.StatusOK, frames)
+}
+
+/*
+pattern: /heatmap/snapshots
+method:  POST
+info:	 json body with snapshot "name", saves the current heatmap of every problem
+
+succeed:
+
+	status code: 201 created
+	response body: json represents saved snapshot
+
+failed:
+
+	status code: 500, 400 ...
+	response body: json with error, time
+*/
+func (h *HTTPHandlers) SaveHeatmapSnapshot(c *gin.Context) {
+	var req snapshotRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	snapshot, err := h.HeatMapService.SaveSnapshot(c, req.Name)
+	if err != nil {
+		respondError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	c.JSON(http.StatusCreated, snapshot)
+}
+
+/*
+pattern: /heatmap/snapshots?named=true&limit=50
+method:  GET
+info:	 optional query params, named=true hides automatic snapshots
+
+succeed:
+
+	status code: 200 OK
+	response body: json represents snapshots newest first, without points
+
+failed:
+
+	status code: 500, 400 ...
+	response body: json with error, time
+*/
+func (h *HTTPHandlers) ListHeatmapSnapshots(c *gin.Context) {
+	named, err := strconv.ParseBool(c.DefaultQuery("named", "false"))
+	if err != nil {
+		respondError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "0"))
+	if err != nil {
+		respondError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	snapshots, err := h.HeatMapService.ListSnapshots(c, named, limit)
+	if err != nil {
+		respondError(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, snapshots)
+}
+
+/*
+pattern: /heatmap/snapshots/:snapshotID
+method:  GET
+info:	 parameters from path
+
+succeed:
+
+	status code: 200 OK
+	response body: json represents snapshot with its heatmap
+
+failed:
+
+	status code: 500, 404, 400 ...
+	response body: json with error, time
+*/
+func (h *HTTPHandlers) GetHeatmapSnapshot(c *gin.Context) {
+	var id snapshotID
+
+	if err := c.ShouldBindUri(&id); err != nil {
+		respondError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	snapshot, err := h.HeatMapService.GetSnapshot(c, id.SnapshotID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		respondError(c, err, http.StatusNotFound)
+		return
+	}
+
+	if err != nil {
+		respondError(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, snapshot)
+}
+
+/*
+pattern: /heatmap/snapshots/diff?from=1&to=2
+method:  GET
+info:	 query params with snapshot ids
+
+succeed:
+
+	status code: 200 OK
+	response body: json represents added, removed and changed importance points and per district deltas
+
+failed:
+
+	status code: 500, 404, 400 ...
+	response body: json with error, time
+*/
+func (h *HTTPHandlers) DiffHeatmapSnapshots(c *gin.Context) {
+	from, err := strconv.Atoi(c.Query("from"))
+	if err != nil {
+		respondError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	to, err := strconv.Atoi(c.Query("to"))
+	if err != nil {
+		respondError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	diff, err := h.HeatMapService.DiffSnapshots(c, from, to)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		respondError(c, err, http.StatusNotFound)
+		return
+	}
+
+	if err != nil {
+		respondError(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, diff)
+}
+
+/*
+pattern: /heatmap/tiles/:z/:x/:y.png?radius=25&ramp=heat&scale=20&type_id=1
+method:  GET
+info:	 parameters from path, optional radius in pixels, ramp is heat/viridis/reds or a comma list of hex colours, problem filters of /heatmap apply
+
+succeed:
+
+	status code: 200 OK
+	response body: png kernel density tile, transparent where there are no problems
+
+failed:
+
+	status code: 500, 400 ...
+	response body: json with error, time
+*/
+func (h *HTTPHandlers) GetHeatmapTile(c *gin.Context) {
+	z, err := strconv.Atoi(c.Param("z"))
+	if err != nil {
+		respondError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	x, err := strconv.Atoi(c.Param("x"))
+	if err != nil {
+		respondError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	yParam, ok := strings.CutSuffix(c.Param("y"), ".png")
+	if !ok {
+		respondError(c, fmt.Errorf("tile must have .png extension"), http.StatusBadRequest)
+		return
+	}
+
+	y, err := strconv.Atoi(yParam)
+	if err != nil {
+		respondError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	radius, err := strconv.Atoi(c.DefaultQuery("radius", "0"))
+	if err != nil {
+		respondError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	scale, err := strconv.ParseFloat(c.DefaultQuery("scale", "0"), 64)
+	if err != nil {
+		respondError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	filter, err := parseProblemFilter(c)
+	if err != nil {
+		respondError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	tile, version, err := h.HeatTileService.GetTile(c, service.HeatTileQuery{
+		Z:      z,
+		X:      x,
+		Y:      y,
+		Radius: radius,
+		Ramp:   c.Query("ramp"),
+		Scale:  scale,
+		Filter: filter,
+	})
+	if err != nil {
+		respondError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	c.Header("Cache-Control", "public, max-age=300")
+	c.Header("X-Heatmap-Version", strconv.FormatInt(version, 10))
+	c.Data(http.StatusOK, "image/png", tile)
+}
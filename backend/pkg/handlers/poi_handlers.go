@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rwrrioe/geomap/backend/pkg/entities"
+)
+
+func parseCategories(raw string) []string {
+	if raw == "" {
+		return nil
+	}
+	return strings.Split(raw, ",")
+}
+
+/*
+pattern: /pois?bbox=minLon,minLat,maxLon,maxLat&category=school,hospital&crs=EPSG:4326
+method:  GET
+info:	 query params, category is an optional comma list of school, hospital, clinic, bus_stop
+
+succeed:
+
+	status code: 200 OK
+	response body: json represents points of interest inside the bbox
+
+failed:
+
+	status code: 500, 400 ...
+	response body: json with error, time
+*/
+func (h *HTTPHandlers) ListPOIs(c *gin.Context) {
+	bbox, err := entities.ParseBBox(c.Query("bbox"))
+	if err != nil {
+		respondError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	srid, err := h.CRSService.ParseSRID(c, c.Query("crs"))
+	if err != nil {
+		respondError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	bbox, err = h.CRSService.ToWGS84BBox(c, bbox, srid)
+	if err != nil {
+		respondError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	pois, err := h.POIService.List(c, bbox, parseCategories(c.Query("category")))
+	if err != nil {
+		respondError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	c.JSON(http.StatusOK, pois)
+}
+
+/*
+pattern: /pois/near?lat=43.25&lon=76.94&radius=300&category=school
+method:  GET
+info:	 query params, radius in meters defaults to the importance scoring radius
+
+succeed:
+
+	status code: 200 OK
+	response body: json represents points of interest nearest first, with distance in meters
+
+failed:
+
+	status code: 500, 400 ...
+	response body: json with error, time
+*/
+func (h *HTTPHandlers) ListPOIsNear(c *gin.Context) {
+	lat, err := strconv.ParseFloat(c.Query("lat"), 64)
+	if err != nil {
+		respondError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	lon, err := strconv.ParseFloat(c.Query("lon"), 64)
+	if err != nil {
+		respondError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	srid, err := h.CRSService.ParseSRID(c, c.Query("crs"))
+	if err != nil {
+		respondError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	lon, lat, err = h.CRSService.ToWGS84Point(c, lon, lat, srid)
+	if err != nil {
+		respondError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	radius, err := strconv.ParseFloat(c.DefaultQuery("radius", "0"), 64)
+	if err != nil {
+		respondError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	pois, err := h.POIService.Near(c, lon, lat, radius, parseCategories(c.Query("category")))
+	if err != nil {
+		respondError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	c.JSON(http.StatusOK, pois)
+}
@@ -15,9 +15,22 @@ import (
 
 type HTTPHandlers struct {
 	*entities.User
-	AIService      *service.AIPredictService
-	HeatMapService *service.HeatMapService
-	ProblemService *service.ProblemService
+	AIService        *service.AIPredictService
+	HeatMapService   *service.HeatMapService
+	ProblemService   *service.ProblemService
+	TileService      *service.TileService
+	HotspotService   *service.HotspotService
+	GeocodingService *service.GeocodingService
+	DistrictService  *service.DistrictService
+	CRSService       *service.CRSService
+	CorridorService  *service.CorridorService
+	ExportService    *service.ExportService
+	POIService       *service.POIService
+	AreaService      *service.AreaService
+	HeatTileService  *service.HeatTileService
+	Scheduler        *service.Scheduler
+	Events           *service.EventBus
+	WebhookService   *service.WebhookService
 }
 
 func respondError(c *gin.Context, err error, status int) {
@@ -30,14 +43,14 @@ func respondError(c *gin.Context, err error, status int) {
 func GuestUser(svc *service.AIPredictService, heatmap *service.HeatMapService, problems *service.ProblemService) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		guest := &HTTPHandlers{
-			&entities.User{
+			User: &entities.User{
 				ID:   0,
 				Name: "guest",
 				Role: "guest",
 			},
-			svc,
-			heatmap,
-			problems,
+			AIService:      svc,
+			HeatMapService: heatmap,
+			ProblemService: problems,
 		}
 		c.Set("currentUser", guest)
 		c.Next()
@@ -45,31 +58,76 @@ func GuestUser(svc *service.AIPredictService, heatmap *service.HeatMapService, p
 }
 
 /*
-pattern: /heatmap
+pattern: /heatmap?type_id=1&status=created&from=2025-01-01&to=2025-02-01&district_id=1,2&min_importance=5&format=binary
 method:  GET
-info:
+info:	 query params are optional, they filter the problems, format is json or binary (see service.EncodeHeatMapBinary), mode=grid returns cells aggregated
+	 into hex or square polygons of size meters as geojson
 
 succeed:
 
-	status code: 200 created
-	response body: json represents heatmap
+	status code: 200 created, 304 not modified when If-None-Match or If-Modified-Since match the data version
+	response body: json represents heatmap with the data version it was built from, also in X-Heatmap-Version and ETag
 
 failed:
 
-	status code: 500 ...
+	status code: 500, 400 ...
 	response body: json with error, time
 */
 
 func (h *HTTPHandlers) GetHeatmap(c *gin.Context) {
-	cachemap, err := h.HeatMapService.GetHeatMap(c)
+	if c.Query("mode") == "grid" {
+		h.getAggregatedHeatmap(c)
+		return
+	}
+
+	filter, err := parseProblemFilter(c)
+	if err != nil {
+		respondError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	format := c.DefaultQuery("format", service.HeatMapFormatJSON)
+	if err := service.ValidateHeatMapFormat(format); err != nil {
+		respondError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	// answer revalidations from the version row alone, before loading the heatmap
+	version, err := h.HeatMapService.DataVersion(c)
 	if err != nil {
 		respondError(c, err, http.StatusInternalServerError)
 		return
 	}
 
-	heatmap := entities.HeatMap{
-		Max:        len(cachemap.HeatMap.HeatPoints),
-		HeatPoints: cachemap.HeatMap.HeatPoints,
+	variant := filter.Key() + "|" + format
+	if setValidators(c, versionETag(version.Version, variant), version.UpdatedAt) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	cachemap, err := h.HeatMapService.GetHeatMap(c, filter)
+	if err != nil {
+		respondError(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	c.Header("ETag", versionETag(cachemap.Version, variant))
+	c.Header("X-Heatmap-Version", strconv.FormatInt(cachemap.Version, 10))
+
+	if format == service.HeatMapFormatBinary {
+		c.Data(http.StatusOK, service.HeatMapBinaryContentType,
+			service.EncodeHeatMapBinary(cachemap.Version, cachemap.HeatMap.HeatPoints))
+		return
+	}
+
+	heatmap := heatmapDTO{
+		HeatMap: entities.HeatMap{
+			Max:        len(cachemap.HeatMap.HeatPoints),
+			HeatPoints: cachemap.HeatMap.HeatPoints,
+		},
+		Filter:  cachemap.FilterKey,
+		Version: cachemap.Version,
+		BuiltAt: cachemap.CreatedAt,
 	}
 
 	c.JSON(http.StatusOK, heatmap)
@@ -304,9 +362,9 @@ func (h *HTTPHandlers) ListProblemsByDistrict(c *gin.Context) {
 }
 
 /*
-pattern: heatmap/districts/:districtID/problems?lat=123&lon=456
+pattern: heatmap/districts/:districtID/problems?lat=123&lon=456&crs=EPSG:4326
 method:  POST
-info:	 parameters in path + query params
+info:	 path + query params, lat/lon may be replaced by address or geometry form field, crs applies to both
 
 succeed:
 
@@ -321,25 +379,49 @@ failed:
 func (h *HTTPHandlers) CreateProblem(c *gin.Context) {
 	var form entities.CreateProblemForm
 
-	lat, err := strconv.ParseFloat(c.Query("lat"), 64)
-	if err != nil {
+	if err := c.ShouldBind(&form); err != nil {
 		respondError(c, err, http.StatusBadRequest)
 		return
 	}
 
-	lon, err := strconv.ParseFloat(c.Query("lon"), 64)
+	srid, err := h.CRSService.ParseSRID(c, c.Query("crs"))
 	if err != nil {
 		respondError(c, err, http.StatusBadRequest)
 		return
 	}
 
-	if err := c.ShouldBind(&form); err != nil {
-		respondError(c, err, http.StatusBadRequest)
-		return
+	switch {
+	case form.Geometry != "":
+		// coordinates are derived from the geometry by the service
+	case c.Query("lat") == "" && c.Query("lon") == "" && form.Address != "":
+		match, err := h.GeocodingService.Resolve(c, form.Address)
+		if err != nil {
+			respondError(c, err, http.StatusBadRequest)
+			return
+		}
+
+		form.Lat = match.Lat
+		form.Lon = match.Lon
+	default:
+		lat, err := strconv.ParseFloat(c.Query("lat"), 64)
+		if err != nil {
+			respondError(c, err, http.StatusBadRequest)
+			return
+		}
+
+		lon, err := strconv.ParseFloat(c.Query("lon"), 64)
+		if err != nil {
+			respondError(c, err, http.StatusBadRequest)
+			return
+		}
+
+		form.Lat = lat
+		form.Lon = lon
 	}
 
-	form.Lat = lat
-	form.Lon = lon
+	if form.Geometry != "" || c.Query("lat") != "" || c.Query("lon") != "" {
+		form.SRID = srid
+	}
 
 	file, err := c.FormFile("file")
 	if err != nil {
@@ -358,7 +440,7 @@ func (h *HTTPHandlers) CreateProblem(c *gin.Context) {
 	}
 
 	form.ImageURL = fmt.Sprintf("http://localhost:8080/uploads/%s", newName)
-	err = h.ProblemService.NewProblem(c, form)
+	err = h.ProblemService.NewProblem(c, &form)
 	if err != nil {
 		respondError(c, err, http.StatusBadRequest)
 		return
@@ -366,3 +448,28 @@ func (h *HTTPHandlers) CreateProblem(c *gin.Context) {
 
 	c.JSON(http.StatusCreated, form)
 }
+
+/*
+pattern: /problems/review
+method:  GET
+info:	 problems reported outside every district, waiting for manual assignment
+
+succeed:
+
+	status code: 200 OK
+	response body: json represents unassigned problems
+
+failed:
+
+	status code: 500 ...
+	response body: json with error, time
+*/
+func (h *HTTPHandlers) ListUnassignedProblems(c *gin.Context) {
+	problems, err := h.ProblemService.ListUnassigned(c)
+	if err != nil {
+		respondError(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, problems)
+}
@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const mvtContentType = "application/vnd.mapbox-vector-tile"
+
+/*
+pattern: /tiles/:layer/:z/:x/:y.mvt
+method:  GET
+info:	 parameters from path, layer is "problems" or "districts"
+
+succeed:
+
+	status code: 200 OK, 204 no content for an empty tile
+	response body: mapbox vector tile
+
+failed:
+
+	status code: 500, 400 ...
+	response body: json with error, time
+*/
+func (h *HTTPHandlers) GetTile(c *gin.Context) {
+	z, err := strconv.Atoi(c.Param("z"))
+	if err != nil {
+		respondError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	x, err := strconv.Atoi(c.Param("x"))
+	if err != nil {
+		respondError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	yParam, ok := strings.CutSuffix(c.Param("y"), ".mvt")
+	if !ok {
+		respondError(c, fmt.Errorf("tile must have .mvt extension"), http.StatusBadRequest)
+		return
+	}
+
+	y, err := strconv.Atoi(yParam)
+	if err != nil {
+		respondError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	tile, err := h.TileService.GetTile(c, c.Param("layer"), z, x, y)
+	if err != nil {
+		respondError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	c.Header("Cache-Control", "public, max-age=300")
+	if len(tile) == 0 {
+		c.Status(http.StatusNoContent)
+		return
+	}
+
+	c.Data(http.StatusOK, mvtContentType, tile)
+}
@@ -0,0 +1,249 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rwrrioe/geomap/backend/pkg/repository"
+	"github.com/rwrrioe/geomap/backend/pkg/service"
+	"gorm.io/gorm"
+)
+
+// respondWebhookError maps webhook service errors to a status
+func respondWebhookError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		respondError(c, err, http.StatusNotFound)
+	case errors.Is(err, service.ErrInvalidWebhook):
+		respondError(c, err, http.StatusBadRequest)
+	default:
+		respondError(c, err, http.StatusInternalServerError)
+	}
+}
+
+/*
+pattern: /admin/webhooks
+method:  POST
+info:	 json body with url, optional secret, event_types, district_ids, type_ids, active; Authorization: Bearer <ADMIN_TOKEN>
+
+succeed:
+
+	status code: 201 created
+	response body: json represents the webhook with its secret, generated when none was given and not shown again
+
+failed:
+
+	status code: 500, 400, 401, 403 ...
+	response body: json with error, time
+*/
+func (h *HTTPHandlers) CreateWebhook(c *gin.Context) {
+	var req webhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	webhook, err := h.WebhookService.Create(c, req.webhook())
+	if err != nil {
+		respondWebhookError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, webhook)
+}
+
+/*
+pattern: /admin/webhooks
+method:  GET
+info:	 Authorization: Bearer <ADMIN_TOKEN>
+
+succeed:
+
+	status code: 200 OK
+	response body: json represents the webhooks without secrets
+
+failed:
+
+	status code: 500, 401, 403 ...
+	response body: json with error, time
+*/
+func (h *HTTPHandlers) ListWebhooks(c *gin.Context) {
+	webhooks, err := h.WebhookService.List(c)
+	if err != nil {
+		respondWebhookError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, webhooks)
+}
+
+/*
+pattern: /admin/webhooks/:webhookID
+method:  GET
+info:	 parameters from path, Authorization: Bearer <ADMIN_TOKEN>
+
+succeed:
+
+	status code: 200 OK
+	response body: json represents the webhook without its secret
+
+failed:
+
+	status code: 500, 404, 400, 401, 403 ...
+	response body: json with error, time
+*/
+func (h *HTTPHandlers) GetWebhook(c *gin.Context) {
+	webhookID, err := strconv.Atoi(c.Param("webhookID"))
+	if err != nil {
+		respondError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	webhook, err := h.WebhookService.Get(c, webhookID)
+	if err != nil {
+		respondWebhookError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, webhook)
+}
+
+/*
+pattern: /admin/webhooks/:webhookID
+method:  PUT
+info:	 parameters from path, json body as for POST /admin/webhooks, an empty secret keeps the current one; Authorization: Bearer <ADMIN_TOKEN>
+
+succeed:
+
+	status code: 200 OK
+	response body: json represents the updated webhook without its secret
+
+failed:
+
+	status code: 500, 404, 400, 401, 403 ...
+	response body: json with error, time
+*/
+func (h *HTTPHandlers) UpdateWebhook(c *gin.Context) {
+	webhookID, err := strconv.Atoi(c.Param("webhookID"))
+	if err != nil {
+		respondError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	var req webhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	webhook, err := h.WebhookService.Update(c, webhookID, req.webhook())
+	if err != nil {
+		respondWebhookError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, webhook)
+}
+
+/*
+pattern: /admin/webhooks/:webhookID
+method:  DELETE
+info:	 parameters from path, Authorization: Bearer <ADMIN_TOKEN>, deletes the delivery log of the webhook too
+
+succeed:
+
+	status code: 204 no content
+
+failed:
+
+	status code: 500, 404, 400, 401, 403 ...
+	response body: json with error, time
+*/
+func (h *HTTPHandlers) DeleteWebhook(c *gin.Context) {
+	webhookID, err := strconv.Atoi(c.Param("webhookID"))
+	if err != nil {
+		respondError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	if err := h.WebhookService.Delete(c, webhookID); err != nil {
+		respondWebhookError(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+/*
+pattern: /admin/webhook-deliveries?webhook_id=1&status=dead&limit=50
+method:  GET
+info:	 status is pending, delivered or dead, status=dead is the dead letter list; Authorization: Bearer <ADMIN_TOKEN>
+
+succeed:
+
+	status code: 200 OK
+	response body: json represents deliveries newest first with attempts, last response status and error
+
+failed:
+
+	status code: 500, 400, 401, 403 ...
+	response body: json with error, time
+*/
+func (h *HTTPHandlers) ListWebhookDeliveries(c *gin.Context) {
+	webhookID, err := strconv.Atoi(c.DefaultQuery("webhook_id", "0"))
+	if err != nil {
+		respondError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "0"))
+	if err != nil {
+		respondError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	deliveries, err := h.WebhookService.ListDeliveries(c, repository.WebhookDeliveryFilter{
+		WebhookID: webhookID,
+		Status:    c.Query("status"),
+		Limit:     limit,
+	})
+	if err != nil {
+		respondWebhookError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, deliveries)
+}
+
+/*
+pattern: /admin/webhook-deliveries/:deliveryID/retry
+method:  POST
+info:	 parameters from path, Authorization: Bearer <ADMIN_TOKEN>, queues the delivery again with a fresh set of attempts
+
+succeed:
+
+	status code: 202 accepted
+	response body: json represents the pending delivery
+
+failed:
+
+	status code: 500, 404, 400, 401, 403 ...
+	response body: json with error, time
+*/
+func (h *HTTPHandlers) RetryWebhookDelivery(c *gin.Context) {
+	deliveryID, err := strconv.ParseInt(c.Param("deliveryID"), 10, 64)
+	if err != nil {
+		respondError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	delivery, err := h.WebhookService.RetryDelivery(c, deliveryID)
+	if err != nil {
+		respondWebhookError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, delivery)
+}
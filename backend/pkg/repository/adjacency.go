@@ -0,0 +1,150 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+type DistrictNeighbourDTO struct {
+	DistrictID   int     `gorm:"column:district_id" json:"district_id"`
+	Name         string  `gorm:"column:district_name" json:"district_name"`
+	BorderLength float64 `gorm:"column:border_length" json:"border_length"`
+}
+
+// DistrictTypeStat is ProblemStatByDistrict for several districts at once
+type DistrictTypeStat struct {
+	DistrictID   int     `gorm:"column:district_id"`
+	TypeID       int     `gorm:"column:type_id"`
+	TypeName     string  `gorm:"column:type"`
+	ProblemCount int     `gorm:"column:prb_count"`
+	SolvedCount  int     `gorm:"column:solved_count"`
+	ImpAvg       float64 `gorm:"column:avg_imp"`
+}
+
+// BorderProblemCount counts problems within a band along the border shared with one neighbour
+type BorderProblemCount struct {
+	NeighbourID   int `gorm:"column:neighbour_id"`
+	OwnSide       int `gorm:"column:own_side"`
+	NeighbourSide int `gorm:"column:neighbour_side"`
+}
+
+type AdjacencyRepository interface {
+	RebuildDistrictAdjacency(ctx context.Context) error
+	ListNeighbours(ctx context.Context, districtID int) ([]DistrictNeighbourDTO, error)
+	GetAnalysisByDistricts(ctx context.Context, ids []int) ([]DistrictTypeStat, error)
+	CountBorderProblems(ctx context.Context, districtID int, band float64) ([]BorderProblemCount, error)
+}
+
+// RebuildDistrictAdjacency recomputes the adjacency graph from the district geometries,
+// both directions of every edge are stored
+func (p *ProblemRepo) RebuildDistrictAdjacency(ctx context.Context) error {
+	return p.Db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("DELETE FROM district_neighbours").Error; err != nil {
+			return fmt.Errorf("db query failed: %w", err)
+		}
+
+		err := tx.Exec(
+			`
+			INSERT INTO district_neighbours (district_id, neighbour_id, border_length)
+			SELECT
+			a.district_id,
+			b.district_id,
+			ST_Length(ST_CollectionExtract(ST_Intersection(a.geom, b.geom), 2)::geography)
+			FROM districts a
+			JOIN districts b ON a.district_id <> b.district_id AND ST_Touches(a.geom, b.geom)
+			`).Error
+		if err != nil {
+			return fmt.Errorf("db query failed: %w", err)
+		}
+
+		return nil
+	})
+}
+
+func (p *ProblemRepo) ListNeighbours(ctx context.Context, districtID int) ([]DistrictNeighbourDTO, error) {
+	var neighbours []DistrictNeighbourDTO
+
+	result := p.Db.WithContext(ctx).Raw(
+		`
+		SELECT
+		n.neighbour_id AS district_id,
+		d.name_ru AS district_name,
+		n.border_length
+		FROM district_neighbours n
+		JOIN districts d ON d.district_id = n.neighbour_id
+		WHERE n.district_id = ?
+		ORDER BY n.border_length DESC
+		`, districtID).Scan(&neighbours)
+
+	if result.Error != nil {
+		return nil, fmt.Errorf("db query failed: %w", result.Error)
+	}
+
+	return neighbours, nil
+}
+
+// GetAnalysisByDistricts returns per type stats of every district in ids
+func (p *ProblemRepo) GetAnalysisByDistricts(ctx context.Context, ids []int) ([]DistrictTypeStat, error) {
+	var stats []DistrictTypeStat
+
+	result := p.Db.WithContext(ctx).Raw(fmt.Sprintf(
+		`
+		WITH members AS (%s)
+		SELECT
+		m.district_id,
+		p.type_id,
+		type,
+		COUNT(p.problem_id) AS prb_count,
+		COUNT(p.problem_id) FILTER (WHERE p.status = 'solved') AS solved_count,
+		AVG(p.importance)::numeric(10,2) AS avg_imp
+		FROM members m
+		JOIN problems p ON p.problem_id = m.problem_id
+		JOIN problem_types USING(type_id)
+		WHERE m.district_id IN ?
+		GROUP BY m.district_id, p.type_id, type
+		ORDER BY m.district_id, p.type_id
+		`, problemMembershipSQL), ids).Scan(&stats)
+
+	if result.Error != nil {
+		return nil, fmt.Errorf("db query failed: %w", result.Error)
+	}
+
+	return stats, nil
+}
+
+// CountBorderProblems counts, for every neighbour, problems within band meters of the
+// shared border on each side of it
+func (p *ProblemRepo) CountBorderProblems(ctx context.Context, districtID int, band float64) ([]BorderProblemCount, error) {
+	var counts []BorderProblemCount
+
+	result := p.Db.WithContext(ctx).Raw(fmt.Sprintf(
+		`
+		WITH members AS (%s),
+		borders AS (
+			SELECT
+			n.neighbour_id,
+			ST_CollectionExtract(ST_Intersection(d.geom, nd.geom), 2)::geography AS border
+			FROM district_neighbours n
+			JOIN districts d ON d.district_id = n.district_id
+			JOIN districts nd ON nd.district_id = n.neighbour_id
+			WHERE n.district_id = ?
+		)
+		SELECT
+		b.neighbour_id,
+		COUNT(m.problem_id) FILTER (WHERE m.district_id = ?) AS own_side,
+		COUNT(m.problem_id) FILTER (WHERE m.district_id = b.neighbour_id) AS neighbour_side
+		FROM borders b
+		LEFT JOIN (members m JOIN problems p ON p.problem_id = m.problem_id)
+		ON m.district_id IN (?, b.neighbour_id)
+		AND ST_DWithin(p.geom::geography, b.border, ?)
+		GROUP BY b.neighbour_id
+		`, problemMembershipSQL), districtID, districtID, districtID, band).Scan(&counts)
+
+	if result.Error != nil {
+		return nil, fmt.Errorf("db query failed: %w", result.Error)
+	}
+
+	return counts, nil
+}
@@ -0,0 +1,63 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/twpayne/go-geom"
+	"github.com/twpayne/go-geom/encoding/wkt"
+)
+
+type AreaRepository interface {
+	GetAnalysisByArea(ctx context.Context, area geom.T) ([]ProblemStatByDistrict, error)
+	AreaSize(ctx context.Context, area geom.T) (float64, error)
+}
+
+// GetAnalysisByArea is GetAnalysisByDistrict for an arbitrary polygon, problems must lie entirely inside it
+func (p *ProblemRepo) GetAnalysisByArea(ctx context.Context, area geom.T) ([]ProblemStatByDistrict, error) {
+	var stats []ProblemStatByDistrict
+
+	areaWKT, err := wkt.NewEncoder().Encode(area)
+	if err != nil {
+		return nil, err
+	}
+
+	result := p.Db.WithContext(ctx).Raw(
+		`
+		SELECT
+		p.type_id,
+		type,
+		COUNT(problem_id) AS prb_count,
+		COUNT(problem_id) FILTER (WHERE status = 'solved') AS solved_count,
+		AVG(importance)::numeric(10,2) AS avg_imp
+		FROM problems p
+		JOIN problem_types USING(type_id)
+		WHERE ST_Within(p.geom, ST_SetSRID(ST_GeomFromText(?), 4326))
+		GROUP BY type_id, type
+		ORDER BY type_id
+		`, areaWKT).Scan(&stats)
+
+	if result.Error != nil {
+		return nil, fmt.Errorf("db query failed: %w", result.Error)
+	}
+
+	return stats, nil
+}
+
+// AreaSize returns the area of a WGS84 polygon in square meters
+func (p *ProblemRepo) AreaSize(ctx context.Context, area geom.T) (float64, error) {
+	var size float64
+
+	areaWKT, err := wkt.NewEncoder().Encode(area)
+	if err != nil {
+		return 0, err
+	}
+
+	result := p.Db.WithContext(ctx).Raw(
+		`SELECT ST_Area(ST_SetSRID(ST_GeomFromText(?), 4326)::geography)`, areaWKT).Scan(&size)
+	if result.Error != nil {
+		return 0, fmt.Errorf("db query failed: %w", result.Error)
+	}
+
+	return size, nil
+}
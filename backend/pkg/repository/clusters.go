@@ -0,0 +1,58 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/rwrrioe/geomap/backend/pkg/entities"
+)
+
+type ProblemCluster struct {
+	ClusterID      int     `gorm:"column:cluster_id" json:"cluster_id"`
+	ProblemCount   int     `gorm:"column:prb_count" json:"problem_count"`
+	DominantTypeID int     `gorm:"column:dominant_type_id" json:"dominant_type_id"`
+	MaxImportance  float64 `gorm:"column:max_imp" json:"max_importance"`
+	Lon            float64 `gorm:"column:lon" json:"lon"`
+	Lat            float64 `gorm:"column:lat" json:"lat"`
+	// set only for single-problem clusters so the frontend can open it directly
+	ProblemID *int `gorm:"column:problem_id" json:"problem_id,omitempty"`
+}
+
+type ClusterRepository interface {
+	ListClusters(ctx context.Context, bbox entities.BBox, eps float64) ([]ProblemCluster, error)
+}
+
+// ListClusters groups problems inside bbox with DBSCAN, eps is in web mercator meters
+func (p *ProblemRepo) ListClusters(ctx context.Context, bbox entities.BBox, eps float64) ([]ProblemCluster, error) {
+	var clusters []ProblemCluster
+
+	result := p.Db.WithContext(ctx).Raw(
+		`
+		WITH pts AS (
+			SELECT
+			problem_id,
+			type_id,
+			importance,
+			geom,
+			ST_ClusterDBSCAN(ST_Transform(geom, 3857), eps := ?, minpoints := 1) OVER () AS cluster_id
+			FROM problems
+			WHERE geom && ST_MakeEnvelope(?, ?, ?, ?, 4326)
+		)
+		SELECT
+		cluster_id,
+		COUNT(*) AS prb_count,
+		mode() WITHIN GROUP (ORDER BY type_id) AS dominant_type_id,
+		MAX(importance) AS max_imp,
+		ST_X(ST_Centroid(ST_Collect(geom))) AS lon,
+		ST_Y(ST_Centroid(ST_Collect(geom))) AS lat,
+		CASE WHEN COUNT(*) = 1 THEN MIN(problem_id) END AS problem_id
+		FROM pts
+		GROUP BY cluster_id
+		ORDER BY prb_count DESC
+		`, eps, bbox.MinLon, bbox.MinLat, bbox.MaxLon, bbox.MaxLat).Scan(&clusters)
+
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return clusters, nil
+}
@@ -0,0 +1,93 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/twpayne/go-geom"
+	"github.com/twpayne/go-geom/encoding/wkt"
+)
+
+type CorridorProblem struct {
+	ProblemID  int     `gorm:"column:problem_id" json:"problem_id"`
+	Name       string  `gorm:"column:name" json:"problem_name"`
+	TypeID     int     `gorm:"column:type_id" json:"problem_typeid"`
+	Status     string  `gorm:"column:status" json:"status"`
+	Importance float64 `gorm:"column:importance" json:"importance"`
+	Lon        float64 `gorm:"column:lon" json:"lon"`
+	Lat        float64 `gorm:"column:lat" json:"lat"`
+	// share of the line length before the closest point, 0 at the start and 1 at the end
+	Fraction float64 `gorm:"column:fraction" json:"fraction"`
+	// distance from the line in meters
+	Offset float64 `gorm:"column:offset_m" json:"offset"`
+	// distance along the line in meters, filled by the service
+	Along float64 `gorm:"-" json:"along"`
+}
+
+type CorridorRepository interface {
+	ListProblemsAlongLine(ctx context.Context, line *geom.LineString, buffer float64) ([]CorridorProblem, error)
+}
+
+// ListProblemsAlongLine returns problems within buffer meters of line ordered from its start
+func (p *ProblemRepo) ListProblemsAlongLine(ctx context.Context, line *geom.LineString, buffer float64) ([]CorridorProblem, error) {
+	var problems []CorridorProblem
+
+	lineWKT, err := wkt.NewEncoder().Encode(line)
+	if err != nil {
+		return nil, err
+	}
+
+	// the closest point is located on the mercator line, where planar fractions follow
+	// ground distance, and its distance from the start is measured on the spheroid
+	result := p.Db.WithContext(ctx).Raw(
+		`
+		WITH line AS (
+			SELECT
+			geom,
+			ST_Transform(geom, 3857) AS geom_m,
+			ST_Length(geom::geography) AS length,
+			-- degrees covering buffer meters, longitude degrees shrink towards the poles
+			ST_Expand(
+				geom,
+				? / (111320 * cos(radians(GREATEST(abs(ST_YMin(geom)), abs(ST_YMax(geom)))))),
+				? / 110574.0
+			) AS envelope
+			FROM (SELECT ST_SetSRID(ST_GeomFromText(?), 4326) AS geom) g
+		),
+		near AS (
+			SELECT
+			p.problem_id,
+			p.name,
+			p.type_id,
+			p.status,
+			p.importance,
+			p.geom,
+			ST_LineLocatePoint(line.geom_m, ST_ClosestPoint(line.geom_m, ST_Transform(p.geom, 3857))) AS m_fraction,
+			ST_Distance(p.geom::geography, line.geom::geography) AS offset_m
+			FROM problems p, line
+			WHERE p.geom && line.envelope
+			AND ST_DWithin(p.geom::geography, line.geom::geography, ?)
+		)
+		SELECT
+		near.problem_id,
+		near.name,
+		near.type_id,
+		near.status,
+		near.importance,
+		ST_X(ST_PointOnSurface(near.geom)) AS lon,
+		ST_Y(ST_PointOnSurface(near.geom)) AS lat,
+		CASE WHEN line.length > 0
+			THEN ST_Length(ST_Transform(ST_LineSubstring(line.geom_m, 0, near.m_fraction), 4326)::geography) / line.length
+			ELSE 0
+		END AS fraction,
+		near.offset_m
+		FROM near, line
+		ORDER BY fraction, offset_m
+		`, buffer, buffer, lineWKT, buffer).Scan(&problems)
+
+	if result.Error != nil {
+		return nil, fmt.Errorf("db query failed: %w", result.Error)
+	}
+
+	return problems, nil
+}
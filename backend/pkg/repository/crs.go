@@ -0,0 +1,41 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/twpayne/go-geom"
+	"github.com/twpayne/go-geom/encoding/wkt"
+)
+
+const SRIDWGS84 = 4326
+
+type CRSRepository interface {
+	IsKnownSRID(ctx context.Context, srid int) bool
+	TransformToWGS84(ctx context.Context, g geom.T, srid int) (geom.T, error)
+}
+
+func (p *ProblemRepo) IsKnownSRID(ctx context.Context, srid int) bool {
+	var count int64
+
+	result := p.Db.WithContext(ctx).Table("spatial_ref_sys").Where("srid = ?", srid).Count(&count)
+	return result.Error == nil && count > 0
+}
+
+// TransformToWGS84 reprojects g from srid with ST_Transform
+func (p *ProblemRepo) TransformToWGS84(ctx context.Context, g geom.T, srid int) (geom.T, error) {
+	geomWKT, err := wkt.NewEncoder().Encode(g)
+	if err != nil {
+		return nil, err
+	}
+
+	var transformed string
+	row := p.Db.WithContext(ctx).Raw(
+		`SELECT ST_AsText(ST_Transform(ST_SetSRID(ST_GeomFromText(?), ?), ?))`,
+		geomWKT, srid, SRIDWGS84).Row()
+	if err := row.Scan(&transformed); err != nil {
+		return nil, fmt.Errorf("db query failed: %w", err)
+	}
+
+	return wkt.Unmarshal(transformed)
+}
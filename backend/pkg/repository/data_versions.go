@@ -0,0 +1,58 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rwrrioe/geomap/backend/pkg/entities"
+	"gorm.io/gorm"
+)
+
+const DataVersionProblems = "problems"
+
+type DataVersionRepository interface {
+	GetDataVersion(ctx context.Context, name string) (entities.DataVersion, error)
+	PruneHeatMaps(ctx context.Context, keep int, before time.Time) (int64, error)
+}
+
+// GetDataVersion returns the change counter of a table, version 0 when nothing was recorded yet
+func (p *ProblemRepo) GetDataVersion(ctx context.Context, name string) (entities.DataVersion, error) {
+	var version entities.DataVersion
+
+	result := p.Db.WithContext(ctx).Where("name = ?", name).First(&version)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return entities.DataVersion{Name: name}, nil
+	}
+
+	if result.Error != nil {
+		return entities.DataVersion{}, fmt.Errorf("db query failed: %w", result.Error)
+	}
+
+	return version, nil
+}
+
+// PruneHeatMaps deletes cached heatmaps created before the cutoff, except named snapshots
+// and the newest keep ones of the unfiltered heatmap. Filtered heatmaps are cheap to
+// rebuild and their keys are unbounded, so they only live until the cutoff.
+func (p *ProblemRepo) PruneHeatMaps(ctx context.Context, keep int, before time.Time) (int64, error) {
+	result := p.Db.WithContext(ctx).Exec(
+		`
+		DELETE FROM cached_heatmaps
+		WHERE created_at < ?
+		AND name IS NULL
+		AND (
+			filter_key <> ''
+			OR heatmap_id NOT IN (
+				SELECT heatmap_id FROM cached_heatmaps WHERE filter_key = '' AND name IS NULL ORDER BY heatmap_id DESC LIMIT ?
+			)
+		)
+		`, before, keep)
+
+	if result.Error != nil {
+		return 0, fmt.Errorf("db query failed: %w", result.Error)
+	}
+
+	return result.RowsAffected, nil
+}
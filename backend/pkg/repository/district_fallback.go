@@ -0,0 +1,101 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/rwrrioe/geomap/backend/pkg/entities"
+	"github.com/twpayne/go-geom"
+	"github.com/twpayne/go-geom/encoding/wkt"
+	"gorm.io/gorm"
+)
+
+var ErrDistrictNotFound = errors.New("no district found for point")
+
+type DistrictFallbackRepository interface {
+	FindDistrictByID(ctx context.Context, id *int) (FindDistrictResponse, error)
+	SnapToDistrict(ctx context.Context, g geom.T, tolerance float64) (FindDistrictResponse, error)
+	ListUnassigned(ctx context.Context) (*[]ProblemDTO, error)
+}
+
+// FindDistrictByID resolves a stored district reference, nil yields an empty unassigned response
+func (p *ProblemRepo) FindDistrictByID(ctx context.Context, id *int) (FindDistrictResponse, error) {
+	if id == nil {
+		return FindDistrictResponse{}, nil
+	}
+
+	var district FindDistrictResponse
+	result := p.Db.WithContext(ctx).Raw(
+		`
+		SELECT
+		district_id,
+		name_ru AS district_name
+		FROM districts
+		WHERE district_id = ?
+		`, *id).Scan(&district)
+
+	if result.Error != nil {
+		return FindDistrictResponse{}, fmt.Errorf("db query failed: %w", result.Error)
+	}
+
+	if result.RowsAffected == 0 {
+		return FindDistrictResponse{}, gorm.ErrRecordNotFound
+	}
+
+	return district, nil
+}
+
+// SnapToDistrict returns the nearest district not further than tolerance meters from any part of g
+func (p *ProblemRepo) SnapToDistrict(ctx context.Context, g geom.T, tolerance float64) (FindDistrictResponse, error) {
+	var district FindDistrictResponse
+
+	geomWKT, err := wkt.NewEncoder().Encode(g)
+	if err != nil {
+		return FindDistrictResponse{}, err
+	}
+
+	result := p.Db.WithContext(ctx).Raw(
+		`
+		WITH pt AS (
+			SELECT ST_SetSRID(ST_GeomFromText(?), 4326) AS geom
+		)
+		SELECT
+		d.district_id,
+		d.name_ru AS district_name
+		FROM districts d, pt
+		WHERE ST_DWithin(d.geom::geography, pt.geom::geography, ?)
+		ORDER BY ST_Distance(d.geom::geography, pt.geom::geography)
+		LIMIT 1
+		`, geomWKT, tolerance).Scan(&district)
+
+	if result.Error != nil {
+		return FindDistrictResponse{}, fmt.Errorf("db query failed: %w", result.Error)
+	}
+
+	if result.RowsAffected == 0 {
+		return FindDistrictResponse{}, fmt.Errorf("%w %s within %.0fm", ErrDistrictNotFound, geomWKT, tolerance)
+	}
+
+	return district, nil
+}
+
+func (p *ProblemRepo) ListUnassigned(ctx context.Context) (*[]ProblemDTO, error) {
+	var problems []entities.Problem
+
+	result := p.Db.WithContext(ctx).Where("needs_review = ?", true).Find(&problems)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	dtos := make([]ProblemDTO, 0, len(problems))
+	for _, prob := range problems {
+		dto, err := newProblemDTO(ctx, p, &prob)
+		if err != nil {
+			return nil, err
+		}
+		dtos = append(dtos, *dto)
+	}
+
+	return &dtos, nil
+}
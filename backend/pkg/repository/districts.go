@@ -0,0 +1,67 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/rwrrioe/geomap/backend/pkg/entities"
+)
+
+type DistrictFeature struct {
+	DistrictID   int     `gorm:"column:district_id"`
+	NameRU       string  `gorm:"column:name_ru"`
+	NameENG      string  `gorm:"column:name_eng"`
+	Reputation   float64 `gorm:"column:reputation"`
+	Geometry     string  `gorm:"column:geometry"`
+	ProblemCount int     `gorm:"column:prb_count"`
+	OpenCount    int     `gorm:"column:open_count"`
+}
+
+type DistrictRepository interface {
+	ListDistrictFeatures(ctx context.Context, tolerance float64, id *int) ([]DistrictFeature, error)
+	ListDistrictIDs(ctx context.Context) ([]int, error)
+}
+
+// ListDistrictFeatures returns districts with geometry simplified by tolerance degrees,
+// a nil id lists every district
+func (p *ProblemRepo) ListDistrictFeatures(ctx context.Context, tolerance float64, id *int) ([]DistrictFeature, error) {
+	var districts []DistrictFeature
+
+	result := p.Db.WithContext(ctx).Raw(fmt.Sprintf(
+		`
+		WITH members AS (%s)
+		SELECT
+		d.district_id,
+		d.name_ru,
+		d.name_eng,
+		COALESCE(d.reputation, 0) AS reputation,
+		ST_AsGeoJSON(ST_SimplifyPreserveTopology(d.geom, ?)) AS geometry,
+		COUNT(p.problem_id) AS prb_count,
+		COUNT(p.problem_id) FILTER (WHERE p.status <> 'solved') AS open_count
+		FROM districts d
+		LEFT JOIN members m ON m.district_id = d.district_id
+		LEFT JOIN problems p ON p.problem_id = m.problem_id
+		WHERE ?::int IS NULL OR d.district_id = ?
+		GROUP BY d.district_id
+		ORDER BY d.district_id
+		`, problemMembershipSQL), tolerance, id, id).Scan(&districts)
+
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return districts, nil
+}
+
+func (p *ProblemRepo) ListDistrictIDs(ctx context.Context) ([]int, error) {
+	var ids []int
+
+	result := p.Db.WithContext(ctx).Model(&entities.District{}).
+		Order("district_id").
+		Pluck("district_id", &ids)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return ids, nil
+}
@@ -0,0 +1,69 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+type ExportRow struct {
+	ProblemID   int        `gorm:"column:problem_id"`
+	Name        string     `gorm:"column:name"`
+	Description string     `gorm:"column:description"`
+	TypeID      int        `gorm:"column:type_id"`
+	Status      string     `gorm:"column:status"`
+	Importance  float64    `gorm:"column:importance"`
+	DistrictID  int        `gorm:"column:district_id"`
+	Address     string     `gorm:"column:address"`
+	CreatedAt   *time.Time `gorm:"column:created_at"`
+	WKT         string     `gorm:"column:wkt"`
+	// representative point, the geometry itself for point problems
+	Lon float64 `gorm:"column:lon"`
+	Lat float64 `gorm:"column:lat"`
+}
+
+type ExportRepository interface {
+	StreamProblems(ctx context.Context, filter ProblemFilter, fn func(*ExportRow) error) error
+}
+
+// StreamProblems walks the filtered problems row by row without loading them all into memory
+func (p *ProblemRepo) StreamProblems(ctx context.Context, filter ProblemFilter, fn func(*ExportRow) error) error {
+	where, args := filter.whereClause("p")
+
+	rows, err := p.Db.WithContext(ctx).Raw(fmt.Sprintf(
+		`
+		SELECT
+		p.problem_id,
+		p.name,
+		p.description,
+		p.type_id,
+		p.status,
+		p.importance,
+		COALESCE(p.district_id, 0) AS district_id,
+		COALESCE(p.address, '') AS address,
+		p.created_at,
+		ST_AsText(p.geom) AS wkt,
+		ST_X(ST_PointOnSurface(p.geom)) AS lon,
+		ST_Y(ST_PointOnSurface(p.geom)) AS lat
+		FROM problems p
+		WHERE %s
+		ORDER BY p.problem_id
+		`, where), args...).Rows()
+	if err != nil {
+		return fmt.Errorf("db query failed: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var row ExportRow
+		if err := p.Db.ScanRows(rows, &row); err != nil {
+			return err
+		}
+
+		if err := fn(&row); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
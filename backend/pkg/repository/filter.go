@@ -0,0 +1,100 @@
+package repository
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ProblemFilter narrows problem queries, zero values mean no restriction
+type ProblemFilter struct {
+	TypeID        *int
+	Status        string
+	DistrictIDs   []int
+	From          *time.Time
+	To            *time.Time
+	MinImportance *float64
+}
+
+// whereClause renders the filter as a raw sql condition over the problems table alias
+func (f ProblemFilter) whereClause(alias string) (string, []interface{}) {
+	conds := []string{"TRUE"}
+	args := []interface{}{}
+
+	if f.TypeID != nil {
+		conds = append(conds, alias+".type_id = ?")
+		args = append(args, *f.TypeID)
+	}
+
+	if f.Status != "" {
+		conds = append(conds, alias+".status = ?")
+		args = append(args, f.Status)
+	}
+
+	if len(f.DistrictIDs) > 0 {
+		conds = append(conds, "("+alias+".district_id IN ? OR "+alias+
+			".problem_id IN (SELECT problem_id FROM problem_districts WHERE district_id IN ?))")
+		args = append(args, f.DistrictIDs, f.DistrictIDs)
+	}
+
+	if f.From != nil {
+		conds = append(conds, alias+".created_at >= ?")
+		args = append(args, *f.From)
+	}
+
+	if f.To != nil {
+		conds = append(conds, alias+".created_at < ?")
+		args = append(args, *f.To)
+	}
+
+	if f.MinImportance != nil {
+		conds = append(conds, alias+".importance >= ?")
+		args = append(args, *f.MinImportance)
+	}
+
+	return strings.Join(conds, " AND "), args
+}
+
+// Key renders the filter in a canonical form, equal filters give equal keys
+// regardless of district order or time zone, the empty filter gives ""
+func (f ProblemFilter) Key() string {
+	var parts []string
+
+	if f.TypeID != nil {
+		parts = append(parts, fmt.Sprintf("type=%d", *f.TypeID))
+	}
+
+	if f.Status != "" {
+		parts = append(parts, "status="+f.Status)
+	}
+
+	if len(f.DistrictIDs) > 0 {
+		ids := append([]int(nil), f.DistrictIDs...)
+		sort.Ints(ids)
+
+		var unique []string
+		for i, id := range ids {
+			if i > 0 && id == ids[i-1] {
+				continue
+			}
+			unique = append(unique, strconv.Itoa(id))
+		}
+		parts = append(parts, "districts="+strings.Join(unique, ","))
+	}
+
+	if f.From != nil {
+		parts = append(parts, "from="+f.From.UTC().Format(time.RFC3339))
+	}
+
+	if f.To != nil {
+		parts = append(parts, "to="+f.To.UTC().Format(time.RFC3339))
+	}
+
+	if f.MinImportance != nil {
+		parts = append(parts, "min_importance="+strconv.FormatFloat(*f.MinImportance, 'f', -1, 64))
+	}
+
+	return strings.Join(parts, ";")
+}
@@ -0,0 +1,52 @@
+package repository
+
+import (
+	"context"
+	"strings"
+)
+
+type GazetteerMatch struct {
+	EntryID     int     `gorm:"column:entry_id" json:"entry_id"`
+	Kind        string  `gorm:"column:kind" json:"kind"`
+	Name        string  `gorm:"column:name" json:"name"`
+	NameRU      string  `gorm:"column:name_ru" json:"name_ru,omitempty"`
+	NameKK      string  `gorm:"column:name_kk" json:"name_kk,omitempty"`
+	HouseNumber string  `gorm:"column:housenumber" json:"housenumber,omitempty"`
+	Lon         float64 `gorm:"column:lon" json:"lon"`
+	Lat         float64 `gorm:"column:lat" json:"lat"`
+	Score       float64 `gorm:"column:score" json:"score"`
+}
+
+type GazetteerRepository interface {
+	SearchGazetteer(ctx context.Context, query string, limit int) ([]GazetteerMatch, error)
+}
+
+// SearchGazetteer ranks entries by trigram word similarity, so typos and partial input still match
+func (p *ProblemRepo) SearchGazetteer(ctx context.Context, query string, limit int) ([]GazetteerMatch, error) {
+	var matches []GazetteerMatch
+	query = strings.ToLower(strings.TrimSpace(query))
+
+	result := p.Db.WithContext(ctx).Raw(
+		`
+		SELECT
+		entry_id,
+		kind,
+		name,
+		name_ru,
+		name_kk,
+		housenumber,
+		ST_X(geom) AS lon,
+		ST_Y(geom) AS lat,
+		word_similarity(?, search_text) AS score
+		FROM gazetteer
+		WHERE ? <% search_text
+		ORDER BY score DESC, kind
+		LIMIT ?
+		`, query, query, limit).Scan(&matches)
+
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return matches, nil
+}
@@ -0,0 +1,92 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/twpayne/go-geom"
+	"github.com/twpayne/go-geom/encoding/wkt"
+)
+
+// search radii in meters
+const (
+	addressSearchRadius = 75
+	streetSearchRadius  = 200
+)
+
+var ErrAddressNotFound = errors.New("no address found near point")
+
+type GeocodedAddress struct {
+	Street      string  `gorm:"column:street" json:"street"`
+	HouseNumber string  `gorm:"column:housenumber" json:"housenumber,omitempty"`
+	Distance    float64 `gorm:"column:distance" json:"distance"`
+}
+
+func (a GeocodedAddress) String() string {
+	if a.HouseNumber == "" {
+		return a.Street
+	}
+	return fmt.Sprintf("%s, %s", a.Street, a.HouseNumber)
+}
+
+type GeocodingRepository interface {
+	ReverseGeocode(ctx context.Context, point geom.Point) (GeocodedAddress, error)
+}
+
+// ReverseGeocode returns the nearest house address, falling back to the nearest named street
+func (p *ProblemRepo) ReverseGeocode(ctx context.Context, point geom.Point) (GeocodedAddress, error) {
+	var address GeocodedAddress
+
+	pointWKT, err := wkt.NewEncoder().Encode(&point)
+	if err != nil {
+		return GeocodedAddress{}, err
+	}
+
+	result := p.Db.WithContext(ctx).Raw(
+		`
+		WITH pt AS (
+			SELECT ST_SetSRID(ST_GeomFromText(?), 4326) AS geom
+		)
+		SELECT
+		a.street,
+		a.housenumber,
+		ST_Distance(a.geom::geography, pt.geom::geography) AS distance
+		FROM osm_addresses a, pt
+		WHERE ST_DWithin(a.geom::geography, pt.geom::geography, ?)
+		ORDER BY a.geom <-> pt.geom
+		LIMIT 1
+		`, pointWKT, addressSearchRadius).Scan(&address)
+
+	if result.Error != nil {
+		return GeocodedAddress{}, fmt.Errorf("db query failed: %w", result.Error)
+	}
+
+	if result.RowsAffected > 0 {
+		return address, nil
+	}
+
+	result = p.Db.WithContext(ctx).Raw(
+		`
+		WITH pt AS (
+			SELECT ST_SetSRID(ST_GeomFromText(?), 4326) AS geom
+		)
+		SELECT
+		s.name AS street,
+		ST_Distance(s.geom::geography, pt.geom::geography) AS distance
+		FROM osm_streets s, pt
+		WHERE ST_DWithin(s.geom::geography, pt.geom::geography, ?)
+		ORDER BY s.geom <-> pt.geom
+		LIMIT 1
+		`, pointWKT, streetSearchRadius).Scan(&address)
+
+	if result.Error != nil {
+		return GeocodedAddress{}, fmt.Errorf("db query failed: %w", result.Error)
+	}
+
+	if result.RowsAffected == 0 {
+		return GeocodedAddress{}, ErrAddressNotFound
+	}
+
+	return address, nil
+}
@@ -0,0 +1,248 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/rwrrioe/geomap/backend/pkg/entities"
+	"github.com/twpayne/go-geom"
+	"github.com/twpayne/go-geom/encoding/wkt"
+	"gorm.io/gorm"
+)
+
+const (
+	GeometryPoint      = "Point"
+	GeometryLineString = "LineString"
+	GeometryPolygon    = "Polygon"
+)
+
+// a line of lineWeightUnit meters or a polygon of areaWeightUnit square meters
+// weighs as much as a single point problem, capped at maxGeometryWeight
+const (
+	lineWeightUnit    = 100
+	areaWeightUnit    = 10000
+	maxGeometryWeight = 10
+)
+
+// HeatSource is a problem reduced to a representative point and a size weight
+type HeatSource struct {
+	ProblemID  int     `gorm:"column:problem_id"`
+	DistrictID int     `gorm:"column:district_id"`
+	TypeID     int     `gorm:"column:type_id"`
+	Importance float64 `gorm:"column:importance"`
+	Lon        float64 `gorm:"column:lon"`
+	Lat        float64 `gorm:"column:lat"`
+	Weight     float64 `gorm:"column:weight"`
+}
+
+// HeatSourceFrame is a heat source with the start of the time bucket it was created in
+type HeatSourceFrame struct {
+	HeatSource `gorm:"embedded"`
+	Bucket     time.Time `gorm:"column:bucket"`
+}
+
+type GeometryRepository interface {
+	FindIntersectingDistricts(ctx context.Context, g geom.T) ([]FindDistrictResponse, error)
+	GeometryInvalidReason(ctx context.Context, g geom.T) (string, error)
+	AddProblemWithDistricts(ctx context.Context, problem *entities.Problem, districtIDs []int) error
+	ListProblemDistrictIDs(ctx context.Context, problemID int) ([]int, error)
+	ListHeatSources(ctx context.Context, filter ProblemFilter) ([]HeatSource, error)
+	ListHeatSourcesInBBox(ctx context.Context, bbox entities.BBox, filter ProblemFilter) ([]HeatSource, error)
+	ListHeatSourceFrames(ctx context.Context, filter ProblemFilter, bucket string) ([]HeatSourceFrame, error)
+}
+
+func geometryType(g geom.T) string {
+	switch g.(type) {
+	case *geom.Point:
+		return GeometryPoint
+	case *geom.LineString:
+		return GeometryLineString
+	case *geom.Polygon:
+		return GeometryPolygon
+	default:
+		return ""
+	}
+}
+
+// FindIntersectingDistricts returns districts crossed by g, largest overlap first
+func (p *ProblemRepo) FindIntersectingDistricts(ctx context.Context, g geom.T) ([]FindDistrictResponse, error) {
+	var districts []FindDistrictResponse
+
+	geomWKT, err := wkt.NewEncoder().Encode(g)
+	if err != nil {
+		return nil, err
+	}
+
+	result := p.Db.WithContext(ctx).Raw(
+		`
+		WITH g AS (
+			SELECT ST_SetSRID(ST_GeomFromText(?), 4326) AS geom
+		)
+		SELECT
+		d.district_id,
+		d.name_ru AS district_name
+		FROM districts d, g
+		WHERE ST_Intersects(d.geom, g.geom)
+		ORDER BY
+		ST_Area(ST_Intersection(d.geom, g.geom)::geography) DESC,
+		ST_Length(ST_Intersection(d.geom, g.geom)::geography) DESC
+		`, geomWKT).Scan(&districts)
+
+	if result.Error != nil {
+		return nil, fmt.Errorf("db query failed: %w", result.Error)
+	}
+
+	return districts, nil
+}
+
+// GeometryInvalidReason explains why g is not a valid OGC geometry, e.g. a self intersecting
+// polygon, and is empty for a valid one
+func (p *ProblemRepo) GeometryInvalidReason(ctx context.Context, g geom.T) (string, error) {
+	var reason string
+
+	geomWKT, err := wkt.NewEncoder().Encode(g)
+	if err != nil {
+		return "", err
+	}
+
+	result := p.Db.WithContext(ctx).Raw(
+		`
+		WITH g AS (
+			SELECT ST_SetSRID(ST_GeomFromText(?), 4326) AS geom
+		)
+		SELECT ST_IsValidReason(g.geom)
+		FROM g
+		WHERE NOT ST_IsValid(g.geom)
+		`, geomWKT).Scan(&reason)
+
+	if result.Error != nil {
+		return "", fmt.Errorf("db query failed: %w", result.Error)
+	}
+
+	return reason, nil
+}
+
+func (p *ProblemRepo) AddProblemWithDistricts(ctx context.Context, problem *entities.Problem, districtIDs []int) error {
+	return p.Db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(problem).Error; err != nil {
+			return err
+		}
+
+		for _, id := range districtIDs {
+			link := entities.ProblemDistrict{ProblemID: problem.ProblemID, DistrictID: id}
+			if err := tx.Create(&link).Error; err != nil {
+				return err
+			}
+		}
+
+		return addOutboxEvents(tx, entities.NewProblemEvent(entities.EventProblemCreated, problem, districtIDs))
+	})
+}
+
+func (p *ProblemRepo) ListProblemDistrictIDs(ctx context.Context, problemID int) ([]int, error) {
+	return problemDistrictIDs(p.Db.WithContext(ctx), problemID)
+}
+
+// problemMembershipSQL pairs every problem with each district it belongs to, its primary
+// district and the districts a line or polygon crosses, the same membership ListByDistrict
+// and the district filter use
+const problemMembershipSQL = `
+	SELECT problem_id, district_id FROM problems WHERE district_id IS NOT NULL
+	UNION
+	SELECT problem_id, district_id FROM problem_districts
+`
+
+func problemDistrictIDs(db *gorm.DB, problemID int) ([]int, error) {
+	var ids []int
+
+	result := db.Model(&entities.ProblemDistrict{}).
+		Where("problem_id = ?", problemID).
+		Order("district_id").
+		Pluck("district_id", &ids)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return ids, nil
+}
+
+// ListHeatSources places lines at their midpoint and polygons at a point on their surface
+func (p *ProblemRepo) ListHeatSources(ctx context.Context, filter ProblemFilter) ([]HeatSource, error) {
+	var sources []HeatSource
+	err := p.listHeatSources(ctx, filter, nil, "", &sources)
+	return sources, err
+}
+
+// ListHeatSourcesInBBox is ListHeatSources limited to problems touching bbox
+func (p *ProblemRepo) ListHeatSourcesInBBox(ctx context.Context, bbox entities.BBox, filter ProblemFilter) ([]HeatSource, error) {
+	var sources []HeatSource
+	err := p.listHeatSources(ctx, filter, &bbox, "", &sources)
+	return sources, err
+}
+
+// ListHeatSourceFrames is ListHeatSources with the UTC day, week or month each problem was
+// created in, ordered by it so a whole range of frames comes from one query
+func (p *ProblemRepo) ListHeatSourceFrames(ctx context.Context, filter ProblemFilter, bucket string) ([]HeatSourceFrame, error) {
+	switch bucket {
+	case "day", "week", "month":
+	default:
+		return nil, fmt.Errorf("unknown frame bucket %q", bucket)
+	}
+
+	var sources []HeatSourceFrame
+	err := p.listHeatSources(ctx, filter, nil, bucket, &sources)
+	return sources, err
+}
+
+// listHeatSources scans into dest, a *[]HeatSource or with a bucket a *[]HeatSourceFrame
+func (p *ProblemRepo) listHeatSources(ctx context.Context, filter ProblemFilter, bbox *entities.BBox, bucket string, dest interface{}) error {
+	where, filterArgs := filter.whereClause("p")
+	if bbox != nil {
+		where += " AND p.geom && ST_MakeEnvelope(?, ?, ?, ?, 4326)"
+		filterArgs = append(filterArgs, bbox.MinLon, bbox.MinLat, bbox.MaxLon, bbox.MaxLat)
+	}
+
+	args := []interface{}{lineWeightUnit, maxGeometryWeight, areaWeightUnit, maxGeometryWeight}
+	bucketColumn, bucketSelect, order := "", "", ""
+	if bucket != "" {
+		bucketColumn = ", date_trunc(?, created_at AT TIME ZONE 'UTC') AS bucket"
+		bucketSelect = ", bucket"
+		order = "ORDER BY bucket, problem_id"
+		args = append(args, bucket)
+	}
+
+	result := p.Db.WithContext(ctx).Raw(fmt.Sprintf(
+		`
+		WITH rep AS (
+			SELECT
+			problem_id,
+			COALESCE(district_id, 0) AS district_id,
+			type_id,
+			importance,
+			CASE GeometryType(geom)
+				WHEN 'LINESTRING' THEN ST_LineInterpolatePoint(geom, 0.5)
+				ELSE ST_PointOnSurface(geom)
+			END AS point,
+			CASE GeometryType(geom)
+				WHEN 'LINESTRING' THEN LEAST(GREATEST(ST_Length(geom::geography) / ?, 1), ?)
+				WHEN 'POLYGON' THEN LEAST(GREATEST(ST_Area(geom::geography) / ?, 1), ?)
+				ELSE 1
+			END AS weight%s
+			FROM problems p
+			WHERE %s
+		)
+		SELECT
+		problem_id,
+		district_id,
+		type_id,
+		importance,
+		ST_X(point) AS lon,
+		ST_Y(point) AS lat,
+		weight%s
+		FROM rep
+		%s
+		`, bucketColumn, where, bucketSelect, order), append(args, filterArgs...)...).Scan(dest)
+
+	return result.Error
+}
@@ -0,0 +1,111 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+)
+
+const (
+	CellShapeHex    = "hex"
+	CellShapeSquare = "square"
+)
+
+type HeatCell struct {
+	Geometry      string  `gorm:"column:geometry"`
+	ProblemCount  int     `gorm:"column:prb_count"`
+	ImportanceSum float64 `gorm:"column:imp_sum"`
+	// json object type_id -> problem count
+	Categories string `gorm:"column:categories"`
+}
+
+type GridRepository interface {
+	AggregateHeatCells(ctx context.Context, shape string, size float64, filter ProblemFilter) ([]HeatCell, error)
+	CountFilteredProblems(ctx context.Context, filter ProblemFilter) (int, error)
+}
+
+// AggregateHeatCells bins the filtered problems into cells of the given shape, size is in ground
+// meters at the center of the problems
+func (p *ProblemRepo) AggregateHeatCells(ctx context.Context, shape string, size float64, filter ProblemFilter) ([]HeatCell, error) {
+	var gridFunc string
+
+	switch shape {
+	case CellShapeHex:
+		gridFunc = "ST_HexagonGrid"
+	case CellShapeSquare:
+		gridFunc = "ST_SquareGrid"
+	default:
+		return nil, fmt.Errorf("unknown cell shape %q", shape)
+	}
+
+	where, filterArgs := filter.whereClause("pr")
+
+	var cells []HeatCell
+	result := p.Db.WithContext(ctx).Raw(fmt.Sprintf(
+		`
+		WITH pts AS (
+			-- lines and polygons fall in one cell through the representative point of ListHeatSources
+			SELECT
+			pr.type_id,
+			pr.importance,
+			ST_Transform(CASE GeometryType(pr.geom)
+				WHEN 'LINESTRING' THEN ST_LineInterpolatePoint(pr.geom, 0.5)
+				ELSE ST_PointOnSurface(pr.geom)
+			END, 3857) AS geom
+			FROM problems pr
+			WHERE %s
+		),
+		bounds AS (
+			SELECT ST_SetSRID(ST_Extent(geom)::geometry, 3857) AS geom FROM pts
+		),
+		cells AS (
+			SELECT grid.i, grid.j, grid.geom
+			-- mercator stretches distances by 1/cos(lat), scale size so cells are size meters across on the ground
+			FROM bounds, %s(? / cos(radians(ST_Y(ST_Transform(ST_Centroid(bounds.geom), 4326)))), bounds.geom) AS grid
+		),
+		by_type AS (
+			SELECT
+			c.i,
+			c.j,
+			c.geom,
+			p.type_id,
+			COUNT(*) AS prb_count,
+			SUM(p.importance) AS imp_sum
+			FROM cells c
+			JOIN pts p ON ST_Intersects(c.geom, p.geom)
+			GROUP BY c.i, c.j, c.geom, p.type_id
+		)
+		SELECT
+		ST_AsGeoJSON(ST_Transform(geom, 4326)) AS geometry,
+		SUM(prb_count) AS prb_count,
+		SUM(imp_sum) AS imp_sum,
+		json_object_agg(type_id, prb_count)::text AS categories
+		FROM by_type
+		GROUP BY i, j, geom
+		`, where, gridFunc), append(filterArgs, size)...).Scan(&cells)
+
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return cells, nil
+}
+
+// CountFilteredProblems counts distinct problems, unlike the sum over cells a geometry
+// crossing several cells is counted once
+func (p *ProblemRepo) CountFilteredProblems(ctx context.Context, filter ProblemFilter) (int, error) {
+	where, args := filter.whereClause("pr")
+
+	var count int
+	result := p.Db.WithContext(ctx).Raw(fmt.Sprintf(
+		`
+		SELECT COUNT(DISTINCT pr.problem_id)
+		FROM problems pr
+		WHERE %s
+		`, where), args...).Scan(&count)
+
+	if result.Error != nil {
+		return 0, fmt.Errorf("db query failed: %w", result.Error)
+	}
+
+	return count, nil
+}
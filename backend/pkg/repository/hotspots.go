@@ -0,0 +1,62 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+)
+
+type GridCellStat struct {
+	I             int     `gorm:"column:i"`
+	J             int     `gorm:"column:j"`
+	Geometry      string  `gorm:"column:geometry"`
+	Lon           float64 `gorm:"column:lon"`
+	Lat           float64 `gorm:"column:lat"`
+	ProblemCount  int     `gorm:"column:prb_count"`
+	ImportanceSum float64 `gorm:"column:imp_sum"`
+}
+
+type HotspotRepository interface {
+	GridCellStats(ctx context.Context, size float64, filter ProblemFilter) ([]GridCellStat, error)
+}
+
+// GridCellStats covers the city with square cells of size meters, empty cells included
+func (p *ProblemRepo) GridCellStats(ctx context.Context, size float64, filter ProblemFilter) ([]GridCellStat, error) {
+	where, filterArgs := filter.whereClause("pr")
+
+	var cells []GridCellStat
+	args := append([]interface{}{size}, filterArgs...)
+	result := p.Db.WithContext(ctx).Raw(fmt.Sprintf(
+		`
+		WITH city AS (
+			SELECT ST_Union(ST_Transform(geom, 3857)) AS geom FROM districts
+		),
+		cells AS (
+			SELECT grid.i, grid.j, grid.geom
+			-- mercator stretches distances by 1/cos(lat), scale size so cells are size meters across on the ground
+			FROM city, ST_SquareGrid(? / cos(radians(ST_Y(ST_Transform(ST_Centroid(city.geom), 4326)))), city.geom) AS grid
+			WHERE ST_Intersects(grid.geom, city.geom)
+		),
+		pts AS (
+			SELECT pr.importance, ST_Transform(pr.geom, 3857) AS geom
+			FROM problems pr
+			WHERE %s
+		)
+		SELECT
+		c.i,
+		c.j,
+		ST_AsGeoJSON(ST_Transform(c.geom, 4326)) AS geometry,
+		ST_X(ST_Transform(ST_Centroid(c.geom), 4326)) AS lon,
+		ST_Y(ST_Transform(ST_Centroid(c.geom), 4326)) AS lat,
+		COUNT(p.geom) AS prb_count,
+		COALESCE(SUM(p.importance), 0) AS imp_sum
+		FROM cells c
+		LEFT JOIN pts p ON ST_Intersects(c.geom, p.geom)
+		GROUP BY c.i, c.j, c.geom
+		`, where), args...).Scan(&cells)
+
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return cells, nil
+}
@@ -0,0 +1,171 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/rwrrioe/geomap/backend/pkg/entities"
+	"gorm.io/gorm"
+)
+
+// first key of the two key advisory locks taken by jobs, keeps them apart from other lock users
+const jobLockNamespace = 0x67656f
+
+type JobRepository interface {
+	TryJobLock(ctx context.Context, job string) (unlock func(), ok bool, err error)
+	StartJobRun(ctx context.Context, run *entities.JobRun) error
+	FinishJobRun(ctx context.Context, run *entities.JobRun, keep int) error
+	ListJobRuns(ctx context.Context, job string, limit int) ([]entities.JobRun, error)
+	LastJobRuns(ctx context.Context) ([]entities.JobRun, error)
+	MarkStaleProblems(ctx context.Context, before time.Time) ([]int, error)
+}
+
+// TryJobLock takes a session advisory lock for the job on a dedicated connection so only one
+// replica runs it at a time. ok is false when another session holds the lock, otherwise
+// unlock must be called to release the lock and the connection.
+func (p *ProblemRepo) TryJobLock(ctx context.Context, job string) (func(), bool, error) {
+	sqlDB, err := p.Db.DB()
+	if err != nil {
+		return nil, false, err
+	}
+
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return nil, false, fmt.Errorf("db connection failed: %w", err)
+	}
+
+	var ok bool
+	err = conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1, hashtext($2))", jobLockNamespace, job).Scan(&ok)
+	if err != nil || !ok {
+		conn.Close()
+		if err != nil {
+			return nil, false, fmt.Errorf("db query failed: %w", err)
+		}
+		return nil, false, nil
+	}
+
+	unlock := func() {
+		// the lock dies with the session anyway, closing a broken connection is enough
+		conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1, hashtext($2))", jobLockNamespace, job)
+		conn.Close()
+	}
+
+	return unlock, true, nil
+}
+
+// StartJobRun records a running job. It must be called with the job lock held, so runs still
+// marked running were left behind by a replica that died and are closed as failed first.
+func (p *ProblemRepo) StartJobRun(ctx context.Context, run *entities.JobRun) error {
+	db := p.Db.WithContext(ctx)
+
+	result := db.Model(&entities.JobRun{}).
+		Where("job = ? AND status = ?", run.Job, entities.JobStatusRunning).
+		Updates(map[string]interface{}{
+			"status":      entities.JobStatusFailed,
+			"error":       "interrupted",
+			"finished_at": time.Now(),
+		})
+	if result.Error != nil {
+		return fmt.Errorf("db query failed: %w", result.Error)
+	}
+
+	if err := db.Create(run).Error; err != nil {
+		return fmt.Errorf("db query failed: %w", err)
+	}
+
+	return nil
+}
+
+// FinishJobRun stores the outcome of run and trims the job history to the newest keep runs
+func (p *ProblemRepo) FinishJobRun(ctx context.Context, run *entities.JobRun, keep int) error {
+	db := p.Db.WithContext(ctx)
+
+	if err := db.Save(run).Error; err != nil {
+		return fmt.Errorf("db query failed: %w", err)
+	}
+
+	result := db.Exec(
+		`
+		DELETE FROM job_runs
+		WHERE job = ?
+		AND run_id NOT IN (
+			SELECT run_id FROM job_runs WHERE job = ? ORDER BY run_id DESC LIMIT ?
+		)
+		`, run.Job, run.Job, keep)
+	if result.Error != nil {
+		return fmt.Errorf("db query failed: %w", result.Error)
+	}
+
+	return nil
+}
+
+// ListJobRuns returns the newest runs of a job first
+func (p *ProblemRepo) ListJobRuns(ctx context.Context, job string, limit int) ([]entities.JobRun, error) {
+	var runs []entities.JobRun
+
+	result := p.Db.WithContext(ctx).
+		Where("job = ?", job).
+		Order("run_id DESC").
+		Limit(limit).
+		Find(&runs)
+	if result.Error != nil {
+		return nil, fmt.Errorf("db query failed: %w", result.Error)
+	}
+
+	return runs, nil
+}
+
+// LastJobRuns returns the newest run of every job that ever ran
+func (p *ProblemRepo) LastJobRuns(ctx context.Context) ([]entities.JobRun, error) {
+	var runs []entities.JobRun
+
+	result := p.Db.WithContext(ctx).Raw(
+		`
+		SELECT DISTINCT ON (job) *
+		FROM job_runs
+		ORDER BY job, run_id DESC
+		`).Scan(&runs)
+	if result.Error != nil {
+		return nil, fmt.Errorf("db query failed: %w", result.Error)
+	}
+
+	return runs, nil
+}
+
+// MarkStaleProblems moves problems nobody picked up since before into the stale status,
+// records a status changed event for each and returns their ids
+func (p *ProblemRepo) MarkStaleProblems(ctx context.Context, before time.Time) ([]int, error) {
+	var ids []int
+
+	err := p.Db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Raw(
+			`
+			UPDATE problems
+			SET status = ?
+			WHERE status = ?
+			AND created_at < ?
+			RETURNING problem_id
+			`, entities.ProblemStatusStale, entities.ProblemStatusCreated, before).Scan(&ids)
+		if result.Error != nil {
+			return fmt.Errorf("db query failed: %w", result.Error)
+		}
+
+		events := make([]entities.ProblemEvent, 0, len(ids))
+		for _, id := range ids {
+			event, err := problemEvent(tx, entities.EventProblemStatusChanged, id)
+			if err != nil {
+				return err
+			}
+			event.PreviousStatus = entities.ProblemStatusCreated
+			events = append(events, event)
+		}
+
+		return addOutboxEvents(tx, events...)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
@@ -0,0 +1,151 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/rwrrioe/geomap/backend/pkg/entities"
+	"gorm.io/gorm"
+)
+
+type OutboxRepository interface {
+	ClaimOutboxEvents(ctx context.Context, limit int, lease time.Duration) ([]entities.OutboxEvent, error)
+	SaveOutboxEvent(ctx context.Context, event *entities.OutboxEvent) error
+	PruneOutbox(ctx context.Context, before time.Time) (int64, error)
+	ListOutboxEventsAfter(ctx context.Context, afterID int64, limit int) ([]entities.OutboxEvent, error)
+	OutboxEventIDRange(ctx context.Context) (first, last int64, err error)
+}
+
+// addOutboxEvents stores events in the transaction of the change that raised them
+func addOutboxEvents(tx *gorm.DB, events ...entities.ProblemEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	rows := make([]entities.OutboxEvent, 0, len(events))
+	for _, e := range events {
+		rows = append(rows, entities.OutboxEvent{
+			Type:          e.Type,
+			ProblemID:     e.ProblemID,
+			Payload:       e,
+			Delivered:     entities.HandlerNames{},
+			NextAttemptAt: time.Now(),
+		})
+	}
+
+	if err := tx.Create(&rows).Error; err != nil {
+		return fmt.Errorf("outbox insert failed: %w", err)
+	}
+
+	return nil
+}
+
+// problemEvent loads the problem inside tx and describes it as an event
+func problemEvent(tx *gorm.DB, eventType string, id int) (entities.ProblemEvent, error) {
+	var problem entities.Problem
+	if err := tx.First(&problem, id).Error; err != nil {
+		return entities.ProblemEvent{}, err
+	}
+
+	districtIDs, err := problemDistrictIDs(tx, id)
+	if err != nil {
+		return entities.ProblemEvent{}, err
+	}
+
+	return entities.NewProblemEvent(eventType, &problem, districtIDs), nil
+}
+
+// ClaimOutboxEvents leases pending events due for delivery, oldest first. Other dispatchers
+// skip them until the lease runs out, so events of a dispatcher that died are picked up again.
+func (p *ProblemRepo) ClaimOutboxEvents(ctx context.Context, limit int, lease time.Duration) ([]entities.OutboxEvent, error) {
+	var events []entities.OutboxEvent
+
+	result := p.Db.WithContext(ctx).Raw(
+		`
+		UPDATE outbox_events
+		SET next_attempt_at = now() + ? * interval '1 millisecond'
+		WHERE event_id IN (
+			SELECT event_id
+			FROM outbox_events
+			WHERE dispatched_at IS NULL
+			AND failed_at IS NULL
+			AND next_attempt_at <= now()
+			ORDER BY event_id
+			LIMIT ?
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING *
+		`, lease.Milliseconds(), limit).Scan(&events)
+	if result.Error != nil {
+		return nil, fmt.Errorf("db query failed: %w", result.Error)
+	}
+
+	// RETURNING has no order
+	sort.Slice(events, func(i, j int) bool { return events[i].EventID < events[j].EventID })
+
+	return events, nil
+}
+
+// SaveOutboxEvent records the delivery state of a claimed event
+func (p *ProblemRepo) SaveOutboxEvent(ctx context.Context, event *entities.OutboxEvent) error {
+	result := p.Db.WithContext(ctx).Model(event).Select(
+		"delivered", "attempts", "last_error", "next_attempt_at", "dispatched_at", "failed_at",
+	).Updates(event)
+	if result.Error != nil {
+		return fmt.Errorf("db query failed: %w", result.Error)
+	}
+
+	return nil
+}
+
+// PruneOutbox deletes events delivered before the cutoff, failed events are kept for inspection
+func (p *ProblemRepo) PruneOutbox(ctx context.Context, before time.Time) (int64, error) {
+	result := p.Db.WithContext(ctx).
+		Where("dispatched_at < ?", before).
+		Delete(&entities.OutboxEvent{})
+	if result.Error != nil {
+		return 0, fmt.Errorf("db query failed: %w", result.Error)
+	}
+
+	return result.RowsAffected, nil
+}
+
+// ListOutboxEventsAfter reads events with ids above afterID in id order without claiming them,
+// every replica tails the table this way to feed its live subscribers
+func (p *ProblemRepo) ListOutboxEventsAfter(ctx context.Context, afterID int64, limit int) ([]entities.OutboxEvent, error) {
+	var events []entities.OutboxEvent
+
+	result := p.Db.WithContext(ctx).
+		Where("event_id > ?", afterID).
+		Order("event_id").
+		Limit(limit).
+		Find(&events)
+	if result.Error != nil {
+		return nil, fmt.Errorf("db query failed: %w", result.Error)
+	}
+
+	return events, nil
+}
+
+// OutboxEventIDRange returns the lowest and highest stored event ids, zeros for an empty table
+func (p *ProblemRepo) OutboxEventIDRange(ctx context.Context) (int64, int64, error) {
+	var bounds struct {
+		First int64 `gorm:"column:first_id"`
+		Last  int64 `gorm:"column:last_id"`
+	}
+
+	result := p.Db.WithContext(ctx).Raw(
+		`
+		SELECT
+		COALESCE(MIN(event_id), 0) AS first_id,
+		COALESCE(MAX(event_id), 0) AS last_id
+		FROM outbox_events
+		`).Scan(&bounds)
+	if result.Error != nil {
+		return 0, 0, fmt.Errorf("db query failed: %w", result.Error)
+	}
+
+	return bounds.First, bounds.Last, nil
+}
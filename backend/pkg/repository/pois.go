@@ -0,0 +1,186 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/rwrrioe/geomap/backend/pkg/entities"
+	"github.com/twpayne/go-geom"
+	"github.com/twpayne/go-geom/encoding/wkt"
+)
+
+type POIDTO struct {
+	POIID    int     `gorm:"column:poi_id" json:"poi_id"`
+	Category string  `gorm:"column:category" json:"category"`
+	Name     string  `gorm:"column:name" json:"name,omitempty"`
+	Lon      float64 `gorm:"column:lon" json:"lon"`
+	Lat      float64 `gorm:"column:lat" json:"lat"`
+	Distance float64 `gorm:"column:distance" json:"distance,omitempty"` // meters, set by radius queries
+}
+
+type POICount struct {
+	Category string `gorm:"column:category" json:"category"`
+	Count    int    `gorm:"column:poi_count" json:"count"`
+}
+
+// ProblemPOIExposure is a problem with the sensitive pois around it
+type ProblemPOIExposure struct {
+	ProblemID  int     `gorm:"column:problem_id" json:"problem_id"`
+	Name       string  `gorm:"column:name" json:"name"`
+	TypeID     int     `gorm:"column:type_id" json:"type_id"`
+	Importance float64 `gorm:"column:importance" json:"importance"`
+	Schools    int     `gorm:"column:schools" json:"schools"`
+	Hospitals  int     `gorm:"column:hospitals" json:"hospitals"`
+	Clinics    int     `gorm:"column:clinics" json:"clinics"`
+	BusStops   int     `gorm:"column:bus_stops" json:"bus_stops"`
+}
+
+type POIRepository interface {
+	ListPOIs(ctx context.Context, bbox entities.BBox, categories []string) ([]POIDTO, error)
+	ListPOIsNear(ctx context.Context, point geom.Point, radius float64, categories []string) ([]POIDTO, error)
+	CountNearbyPOIs(ctx context.Context, g geom.T, radius float64) ([]POICount, error)
+	DistrictPOIExposure(ctx context.Context, districtID int, radius float64, limit int) ([]ProblemPOIExposure, error)
+}
+
+// categoryClause restricts pois to the categories, none means every category
+func categoryClause(categories []string) (string, []interface{}) {
+	if len(categories) == 0 {
+		return "TRUE", nil
+	}
+	return "category IN ?", []interface{}{categories}
+}
+
+// expandMeters is the bounding box of the 4326 geometry expression g grown by ? meters,
+// a && prefilter with it lets ST_DWithin on geography use the gist index of pois.geom.
+// The distance is bound twice, longitude degrees shrink towards the poles.
+func expandMeters(g string) string {
+	return fmt.Sprintf(
+		"ST_Expand(%[1]s, ? / (111320 * cos(radians(GREATEST(abs(ST_YMin(%[1]s)), abs(ST_YMax(%[1]s)))))), ? / 110574.0)", g)
+}
+
+func (p *ProblemRepo) ListPOIs(ctx context.Context, bbox entities.BBox, categories []string) ([]POIDTO, error) {
+	var pois []POIDTO
+	where, args := categoryClause(categories)
+
+	result := p.Db.WithContext(ctx).Raw(fmt.Sprintf(
+		`
+		SELECT
+		poi_id,
+		category,
+		name,
+		ST_X(geom) AS lon,
+		ST_Y(geom) AS lat
+		FROM pois
+		WHERE geom && ST_MakeEnvelope(?, ?, ?, ?, 4326)
+		AND %s
+		ORDER BY poi_id
+		`, where), append([]interface{}{bbox.MinLon, bbox.MinLat, bbox.MaxLon, bbox.MaxLat}, args...)...).Scan(&pois)
+
+	if result.Error != nil {
+		return nil, fmt.Errorf("db query failed: %w", result.Error)
+	}
+
+	return pois, nil
+}
+
+// ListPOIsNear returns pois within radius meters of point, nearest first
+func (p *ProblemRepo) ListPOIsNear(ctx context.Context, point geom.Point, radius float64, categories []string) ([]POIDTO, error) {
+	var pois []POIDTO
+
+	pointWKT, err := wkt.NewEncoder().Encode(&point)
+	if err != nil {
+		return nil, err
+	}
+
+	where, args := categoryClause(categories)
+	result := p.Db.WithContext(ctx).Raw(fmt.Sprintf(
+		`
+		WITH g AS (
+			SELECT geom::geography AS geog, %s AS envelope
+			FROM (SELECT ST_SetSRID(ST_GeomFromText(?), 4326) AS geom) g
+		)
+		SELECT
+		poi_id,
+		category,
+		name,
+		ST_X(geom) AS lon,
+		ST_Y(geom) AS lat,
+		ST_Distance(geom::geography, g.geog) AS distance
+		FROM pois, g
+		WHERE geom && g.envelope
+		AND ST_DWithin(geom::geography, g.geog, ?)
+		AND %s
+		ORDER BY distance
+		`, expandMeters("geom"), where), append([]interface{}{radius, radius, pointWKT, radius}, args...)...).Scan(&pois)
+
+	if result.Error != nil {
+		return nil, fmt.Errorf("db query failed: %w", result.Error)
+	}
+
+	return pois, nil
+}
+
+// CountNearbyPOIs counts pois per category within radius meters of any part of g
+func (p *ProblemRepo) CountNearbyPOIs(ctx context.Context, g geom.T, radius float64) ([]POICount, error) {
+	var counts []POICount
+
+	geomWKT, err := wkt.NewEncoder().Encode(g)
+	if err != nil {
+		return nil, err
+	}
+
+	result := p.Db.WithContext(ctx).Raw(fmt.Sprintf(
+		`
+		WITH g AS (
+			SELECT geom::geography AS geog, %s AS envelope
+			FROM (SELECT ST_SetSRID(ST_GeomFromText(?), 4326) AS geom) g
+		)
+		SELECT
+		category,
+		COUNT(*) AS poi_count
+		FROM pois, g
+		WHERE geom && g.envelope
+		AND ST_DWithin(geom::geography, g.geog, ?)
+		GROUP BY category
+		`, expandMeters("geom")), radius, radius, geomWKT, radius).Scan(&counts)
+
+	if result.Error != nil {
+		return nil, fmt.Errorf("db query failed: %w", result.Error)
+	}
+
+	return counts, nil
+}
+
+// DistrictPOIExposure lists open problems of the district that have sensitive pois
+// within radius meters, most exposed first
+func (p *ProblemRepo) DistrictPOIExposure(ctx context.Context, districtID int, radius float64, limit int) ([]ProblemPOIExposure, error) {
+	var exposure []ProblemPOIExposure
+
+	result := p.Db.WithContext(ctx).Raw(fmt.Sprintf(
+		`
+		SELECT
+		p.problem_id,
+		p.name,
+		p.type_id,
+		p.importance,
+		COUNT(*) FILTER (WHERE poi.category = ?) AS schools,
+		COUNT(*) FILTER (WHERE poi.category = ?) AS hospitals,
+		COUNT(*) FILTER (WHERE poi.category = ?) AS clinics,
+		COUNT(*) FILTER (WHERE poi.category = ?) AS bus_stops
+		FROM problems p
+		JOIN pois poi ON poi.geom && %s
+		AND ST_DWithin(poi.geom::geography, p.geom::geography, ?)
+		WHERE p.status <> 'solved'
+		AND (p.district_id = ? OR p.problem_id IN (SELECT problem_id FROM problem_districts WHERE district_id = ?))
+		GROUP BY p.problem_id, p.name, p.type_id, p.importance
+		ORDER BY COUNT(*) DESC, p.importance DESC
+		LIMIT ?
+		`, expandMeters("p.geom")), entities.POISchool, entities.POIHospital, entities.POIClinic, entities.POIBusStop,
+		radius, radius, radius, districtID, districtID, limit).Scan(&exposure)
+
+	if result.Error != nil {
+		return nil, fmt.Errorf("db query failed: %w", result.Error)
+	}
+
+	return exposure, nil
+}
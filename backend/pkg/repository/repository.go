@@ -12,8 +12,8 @@ import (
 	"github.com/rwrrioe/geomap/backend/pkg/entities"
 	"github.com/twpayne/go-geom"
 	"github.com/twpayne/go-geom/encoding/wkt"
-	"github.com/ybru-tech/georm"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type FindDistrictResponse struct {
@@ -22,11 +22,11 @@ type FindDistrictResponse struct {
 }
 
 type ProblemStatByDistrict struct {
-	TypeID       int     `gorm:"column:type_id"`
-	TypeName     string  `gorm:"column:type"`
-	ProblemCount int     `gorm:"column:prb_count"`
-	SolvedCount  int     `gorm:"column:solved_count"`
-	ImpAvg       float64 `gorm:"column:avg_imp"`
+	TypeID       int     `gorm:"column:type_id" json:"type_id"`
+	TypeName     string  `gorm:"column:type" json:"type_name"`
+	ProblemCount int     `gorm:"column:prb_count" json:"problems_count"`
+	SolvedCount  int     `gorm:"column:solved_count" json:"solved_count"`
+	ImpAvg       float64 `gorm:"column:avg_imp" json:"imp_avg"`
 }
 
 type ProblemStatByType struct {
@@ -44,20 +44,38 @@ type ProblemStatByCity struct {
 }
 
 type ProblemDTO struct {
-	ProblemID    int         `json:"problem_id"`
-	DistrictName string      `json:"district_name"`
-	DistrictId   int         `json:"district_id"`
-	Geom         georm.Point `json:"geom,omitempty"`
-	Name         string      `json:"problem_name"`
-	Description  string      `json:"problem_desc"`
-	ImageURL     string      `gorm:"column:image_url" json:"image_url"`
-	Importance   float64     `json:"importance"`
-	Status       string      `json:"status"`
-	TypeID       int         `json:"problem_typeid"`
+	ProblemID    int                      `json:"problem_id"`
+	DistrictName string                   `json:"district_name"`
+	DistrictId   int                      `json:"district_id"`
+	DistrictIDs  []int                    `json:"district_ids,omitempty"`
+	Geom         entities.ProblemGeometry `json:"geom,omitempty"`
+	GeometryType string                   `json:"geometry_type"`
+	Name         string                   `json:"problem_name"`
+	Description  string                   `json:"problem_desc"`
+	ImageURL     string                   `gorm:"column:image_url" json:"image_url"`
+	Importance   float64                  `json:"importance"`
+	Status       string                   `json:"status"`
+	TypeID       int                      `json:"problem_typeid"`
+	Address      string                   `json:"address,omitempty"`
 }
 
 func newProblemDTO(ctx context.Context, repo ProblemRepository, p *entities.Problem) (*ProblemDTO, error) {
-	district, err := repo.FindDistrict(ctx, *p.Geom.Geom)
+	var district FindDistrictResponse
+	var districtIDs []int
+	var err error
+
+	if point, ok := p.Geom.Geom.(*geom.Point); ok {
+		district, err = repo.FindDistrict(ctx, *point)
+		if errors.Is(err, ErrDistrictNotFound) {
+			// snapped or unassigned problem, trust the stored district
+			district, err = repo.FindDistrictByID(ctx, p.DistrictID)
+		}
+	} else {
+		district, err = repo.FindDistrictByID(ctx, p.DistrictID)
+		if err == nil {
+			districtIDs, err = repo.ListProblemDistrictIDs(ctx, p.ProblemID)
+		}
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -66,12 +84,15 @@ func newProblemDTO(ctx context.Context, repo ProblemRepository, p *entities.Prob
 		ProblemID:    p.ProblemID,
 		DistrictName: district.District_name,
 		DistrictId:   district.District_ID,
+		DistrictIDs:  districtIDs,
 		Geom:         p.Geom,
+		GeometryType: geometryType(p.Geom.Geom),
 		Name:         p.Name,
 		Description:  p.Description,
 		ImageURL:     p.ImageURL,
 		Importance:   p.Importance,
 		TypeID:       p.TypeId,
+		Address:      p.Address,
 
 		Status: p.Status,
 	}, nil
@@ -84,15 +105,38 @@ type ProblemRepository interface {
 	GetAnalysisByType(ctx context.Context, id int) ([]ProblemStatByType, error)
 	GetAnalysisByCity(ctx context.Context) (ProblemStatByCity, error)
 	FindDistrict(ctx context.Context, point geom.Point) (FindDistrictResponse, error)
-	AddProblem(ctx context.Context, problem entities.Problem) error
+	AddProblem(ctx context.Context, problem *entities.Problem) error
+	UpdateProblemStatus(ctx context.Context, id int, status string) (string, error)
+	UpdateProblem(ctx context.Context, id int, update ProblemUpdate) error
+	DeleteProblem(ctx context.Context, id int) error
 	ListProblems(ctx context.Context) (*[]ProblemDTO, error)
 	GetAIResponseById(ctx context.Context, id int) (*entities.CachedAnswer, error)
 	CacheAIResponse(ctx context.Context, aiResponse *entities.ExtendedAIResponse, requestID int) error
-	CacheHeatMap(ctx context.Context, heatmap *entities.HeatMap) error
-	GetHeatMap(ctx context.Context) (*entities.CachedHeatMap, error)
+	CacheHeatMap(ctx context.Context, heatmap *entities.HeatMap, filterKey string, version int64) error
+	GetHeatMap(ctx context.Context, filterKey string) (*entities.CachedHeatMap, error)
 	IsDistrict(ctx context.Context, id int) bool
 	IsProblemType(ctx context.Context, id int) bool
 	GetDb() *ProblemRepo
+	TileRepository
+	ClusterRepository
+	GridRepository
+	HotspotRepository
+	GeocodingRepository
+	GazetteerRepository
+	DistrictFallbackRepository
+	DistrictRepository
+	GeometryRepository
+	CRSRepository
+	CorridorRepository
+	ExportRepository
+	POIRepository
+	AreaRepository
+	AdjacencyRepository
+	DataVersionRepository
+	SnapshotRepository
+	JobRepository
+	OutboxRepository
+	WebhookRepository
 }
 
 type ProblemRepo struct {
@@ -150,12 +194,14 @@ func (p *ProblemRepo) CacheAIResponse(ctx context.Context, aiResponse *entities.
 	return nil
 }
 
-func (p *ProblemRepo) CacheHeatMap(ctx context.Context, heatmap *entities.HeatMap) error {
+func (p *ProblemRepo) CacheHeatMap(ctx context.Context, heatmap *entities.HeatMap, filterKey string, version int64) error {
 	cachedHeatMap := entities.CachedHeatMap{
 		HeatMap: entities.HeatMap{
 			Max:        heatmap.Max,
 			HeatPoints: heatmap.HeatPoints,
 		},
+		FilterKey: filterKey,
+		Version:   version,
 	}
 
 	result := p.Db.Create(&cachedHeatMap)
@@ -165,10 +211,10 @@ func (p *ProblemRepo) CacheHeatMap(ctx context.Context, heatmap *entities.HeatMa
 	return nil
 }
 
-func (p *ProblemRepo) GetHeatMap(ctx context.Context) (*entities.CachedHeatMap, error) {
+func (p *ProblemRepo) GetHeatMap(ctx context.Context, filterKey string) (*entities.CachedHeatMap, error) {
 	var heatmap entities.CachedHeatMap
 
-	result := p.Db.WithContext(ctx).Last(&heatmap)
+	result := p.Db.WithContext(ctx).Where("filter_key = ?", filterKey).Last(&heatmap)
 	if result.Error != nil {
 		return nil, result.Error
 	}
@@ -176,10 +222,13 @@ func (p *ProblemRepo) GetHeatMap(ctx context.Context) (*entities.CachedHeatMap,
 	return &heatmap, nil
 }
 
+// GetById reads problems directly, the problems_with_importance view keeps the column list
+// it was created with and misses address, needs_review and created_at. Importance is stored
+// on the row when the problem is reported.
 func (p *ProblemRepo) GetById(ctx context.Context, id int) (*ProblemDTO, error) {
 	var problem entities.Problem
 
-	result := p.Db.WithContext(ctx).Table("problems_with_importance").First(&problem, id)
+	result := p.Db.WithContext(ctx).First(&problem, id)
 	if result.Error != nil {
 		return nil, result.Error
 	}
@@ -195,7 +244,9 @@ func (p *ProblemRepo) GetById(ctx context.Context, id int) (*ProblemDTO, error)
 func (p *ProblemRepo) ListByDistrict(ctx context.Context, id int) (*[]ProblemDTO, error) {
 	var problems []entities.Problem
 
-	result := p.Db.WithContext(ctx).Preload("District").Where("district_id=?", id).Find(&problems)
+	result := p.Db.WithContext(ctx).Preload("District").
+		Where("district_id=? OR problem_id IN (SELECT problem_id FROM problem_districts WHERE district_id=?)", id, id).
+		Find(&problems)
 	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
 		return nil, gorm.ErrRecordNotFound
 	}
@@ -227,8 +278,8 @@ func (p *ProblemRepo) GetAnalysisByDistrict(ctx context.Context, id int) ([]Prob
                 avg(importance)::numeric(10,2) as avg_imp
                 FROM problems p
 				JOIN problem_types USING(type_id)
-				WHERE district_id = ?
-                GROUP BY type_id, type`, id).Scan(&stats)
+				WHERE district_id = ? OR problem_id IN (SELECT problem_id FROM problem_districts WHERE district_id = ?)
+                GROUP BY type_id, type`, id, id).Scan(&stats)
 
 	if result.Error != nil {
 		return nil, result.Error
@@ -241,19 +292,21 @@ func (p *ProblemRepo) GetAnalysisByDistrict(ctx context.Context, id int) ([]Prob
 func (p *ProblemRepo) GetAnalysisByType(ctx context.Context, id int) ([]ProblemStatByType, error) {
 	var statByType []ProblemStatByType
 
-	result := p.Db.Raw(
-		`SELECT 
-		p.district_id,
+	result := p.Db.Raw(fmt.Sprintf(
+		`WITH members AS (%s)
+		SELECT 
+		d.district_id,
 		d.name_ru as district_name,
-		COUNT(problem_id) AS prb_count,
+		COUNT(p.problem_id) AS prb_count,
 		AVG(p.importance)::numeric(10,2) as avg_imp,
 		COUNT(*) FILTER (WHERE p.status = 'solved') as solved_count
 		FROM problems p
-		JOIN districts d USING(district_id)
+		JOIN members m ON m.problem_id = p.problem_id
+		JOIN districts d ON d.district_id = m.district_id
 		WHERE p.type_id = ?
-		GROUP BY p.district_id, d.name_ru
+		GROUP BY d.district_id, d.name_ru
 		ORDER BY prb_count DESC
-		`, id).Scan(&statByType)
+		`, problemMembershipSQL), id).Scan(&statByType)
 
 	if result.Error != nil {
 		return nil, result.Error
@@ -304,18 +357,149 @@ func (p *ProblemRepo) FindDistrict(ctx context.Context, point geom.Point) (FindD
 	}
 
 	if result.RowsAffected == 0 {
-		return FindDistrictResponse{}, fmt.Errorf("no district found for point %s", pointWKT)
+		return FindDistrictResponse{}, fmt.Errorf("%w %s", ErrDistrictNotFound, pointWKT)
 	}
 
 	return district, nil
 }
 
-func (p *ProblemRepo) AddProblem(ctx context.Context, problem entities.Problem) error {
-	result := p.Db.Create(&problem)
-	if result.Error != nil {
-		return result.Error
+func (p *ProblemRepo) AddProblem(ctx context.Context, problem *entities.Problem) error {
+	return p.Db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(problem).Error; err != nil {
+			return err
+		}
+
+		return addOutboxEvents(tx, entities.NewProblemEvent(entities.EventProblemCreated, problem, nil))
+	})
+}
+
+// UpdateProblemStatus sets the status and returns the previous one,
+// an actual change is recorded as a status changed event
+func (p *ProblemRepo) UpdateProblemStatus(ctx context.Context, id int, status string) (string, error) {
+	var previous []string
+
+	err := p.Db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Raw(
+			`
+			UPDATE problems p
+			SET status = ?
+			FROM (SELECT problem_id, status FROM problems WHERE problem_id = ? FOR UPDATE) old
+			WHERE p.problem_id = old.problem_id
+			RETURNING old.status
+			`, status, id).Scan(&previous)
+		if result.Error != nil {
+			return fmt.Errorf("db query failed: %w", result.Error)
+		}
+
+		if len(previous) == 0 {
+			return gorm.ErrRecordNotFound
+		}
+
+		if previous[0] == status {
+			return nil
+		}
+
+		event, err := problemEvent(tx, entities.EventProblemStatusChanged, id)
+		if err != nil {
+			return err
+		}
+		event.PreviousStatus = previous[0]
+
+		return addOutboxEvents(tx, event)
+	})
+	if err != nil {
+		return "", err
 	}
-	return nil
+
+	return previous[0], nil
+}
+
+// ProblemUpdate holds the editable fields of a problem, nil fields are left as they are
+type ProblemUpdate struct {
+	Name        *string
+	Description *string
+	TypeID      *int
+	// assigning a district takes the problem out of the review bucket, for a line or polygon
+	// it also replaces the districts the geometry crosses
+	DistrictID *int
+}
+
+// UpdateProblem applies the update, an actual change is recorded as an updated event
+func (p *ProblemRepo) UpdateProblem(ctx context.Context, id int, update ProblemUpdate) error {
+	return p.Db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var problem entities.Problem
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&problem, id).Error; err != nil {
+			return err
+		}
+
+		changes := map[string]interface{}{}
+		if update.Name != nil && *update.Name != problem.Name {
+			changes["name"] = *update.Name
+		}
+
+		if update.Description != nil && *update.Description != problem.Description {
+			changes["description"] = *update.Description
+		}
+
+		if update.TypeID != nil && *update.TypeID != problem.TypeId {
+			changes["type_id"] = *update.TypeID
+		}
+
+		if update.DistrictID != nil && (problem.DistrictID == nil || *update.DistrictID != *problem.DistrictID || problem.NeedsReview) {
+			changes["district_id"] = *update.DistrictID
+			changes["needs_review"] = false
+		}
+
+		if len(changes) == 0 {
+			return nil
+		}
+
+		if err := tx.Model(&entities.Problem{}).Where("problem_id = ?", id).Updates(changes).Error; err != nil {
+			return fmt.Errorf("db query failed: %w", err)
+		}
+
+		if _, ok := changes["district_id"]; ok {
+			// stale links would keep the problem listed and matched under its old districts
+			result := tx.Where("problem_id = ?", id).Delete(&entities.ProblemDistrict{})
+			if result.Error != nil {
+				return fmt.Errorf("db query failed: %w", result.Error)
+			}
+
+			if result.RowsAffected > 0 {
+				link := entities.ProblemDistrict{ProblemID: id, DistrictID: *update.DistrictID}
+				if err := tx.Create(&link).Error; err != nil {
+					return fmt.Errorf("db query failed: %w", err)
+				}
+			}
+		}
+
+		event, err := problemEvent(tx, entities.EventProblemUpdated, id)
+		if err != nil {
+			return err
+		}
+
+		return addOutboxEvents(tx, event)
+	})
+}
+
+// DeleteProblem removes the problem with its district links and records a deleted event
+func (p *ProblemRepo) DeleteProblem(ctx context.Context, id int) error {
+	return p.Db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		event, err := problemEvent(tx, entities.EventProblemDeleted, id)
+		if err != nil {
+			return err
+		}
+
+		if err := tx.Where("problem_id = ?", id).Delete(&entities.ProblemDistrict{}).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Delete(&entities.Problem{}, id).Error; err != nil {
+			return err
+		}
+
+		return addOutboxEvents(tx, event)
+	})
 }
 
 func (p *ProblemRepo) ListProblems(ctx context.Context) (*[]ProblemDTO, error) {
@@ -0,0 +1,83 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/rwrrioe/geomap/backend/pkg/entities"
+)
+
+// SnapshotInfo describes a cached heatmap without its points
+type SnapshotInfo struct {
+	HeatMapID  int       `gorm:"column:heatmap_id" json:"snapshot_id"`
+	Name       *string   `gorm:"column:name" json:"name"`
+	FilterKey  string    `gorm:"column:filter_key" json:"filter,omitempty"`
+	Version    int64     `gorm:"column:data_version" json:"version"`
+	PointCount int       `gorm:"column:point_count" json:"point_count"`
+	CreatedAt  time.Time `gorm:"column:created_at" json:"created_at"`
+}
+
+type SnapshotRepository interface {
+	SaveSnapshot(ctx context.Context, name string, heatmap *entities.CachedHeatMap) (*entities.CachedHeatMap, error)
+	ListSnapshots(ctx context.Context, namedOnly bool, limit int) ([]SnapshotInfo, error)
+	GetSnapshot(ctx context.Context, id int) (*entities.CachedHeatMap, error)
+}
+
+// SaveSnapshot stores a named copy of heatmap, the copy is exempt from pruning
+func (p *ProblemRepo) SaveSnapshot(ctx context.Context, name string, heatmap *entities.CachedHeatMap) (*entities.CachedHeatMap, error) {
+	snapshot := entities.CachedHeatMap{
+		HeatMap:   heatmap.HeatMap,
+		Name:      &name,
+		FilterKey: heatmap.FilterKey,
+		Version:   heatmap.Version,
+	}
+
+	result := p.Db.WithContext(ctx).Create(&snapshot)
+	if result.Error != nil {
+		return nil, fmt.Errorf("db query failed: %w", result.Error)
+	}
+
+	return &snapshot, nil
+}
+
+// ListSnapshots returns the newest snapshots of the unfiltered heatmap first
+func (p *ProblemRepo) ListSnapshots(ctx context.Context, namedOnly bool, limit int) ([]SnapshotInfo, error) {
+	var snapshots []SnapshotInfo
+
+	result := p.Db.WithContext(ctx).Raw(
+		`
+		SELECT
+		heatmap_id,
+		name,
+		filter_key,
+		data_version,
+		CASE json_typeof(heatmap_data -> 'heat_points')
+			WHEN 'array' THEN json_array_length(heatmap_data -> 'heat_points')
+			ELSE 0
+		END AS point_count,
+		created_at
+		FROM cached_heatmaps
+		WHERE filter_key = ''
+		AND (NOT ? OR name IS NOT NULL)
+		ORDER BY heatmap_id DESC
+		LIMIT ?
+		`, namedOnly, limit).Scan(&snapshots)
+
+	if result.Error != nil {
+		return nil, fmt.Errorf("db query failed: %w", result.Error)
+	}
+
+	return snapshots, nil
+}
+
+func (p *ProblemRepo) GetSnapshot(ctx context.Context, id int) (*entities.CachedHeatMap, error) {
+	var snapshot entities.CachedHeatMap
+
+	result := p.Db.WithContext(ctx).First(&snapshot, id)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return &snapshot, nil
+}
@@ -0,0 +1,87 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+)
+
+const (
+	TileLayerProblems  = "problems"
+	TileLayerDistricts = "districts"
+)
+
+// zoom from which tiles carry the full attribute set
+const (
+	problemsDetailZoom  = 13
+	districtsDetailZoom = 11
+)
+
+type TileRepository interface {
+	GetTile(ctx context.Context, layer string, z, x, y int) ([]byte, error)
+}
+
+func (p *ProblemRepo) GetTile(ctx context.Context, layer string, z, x, y int) ([]byte, error) {
+	var query string
+
+	switch layer {
+	case TileLayerProblems:
+		query = problemsTileQuery(z)
+	case TileLayerDistricts:
+		query = districtsTileQuery(z)
+	default:
+		return nil, fmt.Errorf("unknown tile layer %q", layer)
+	}
+
+	var tile []byte
+	row := p.Db.WithContext(ctx).Raw(query, z, x, y).Row()
+	if err := row.Scan(&tile); err != nil {
+		return nil, fmt.Errorf("db query failed: %w", err)
+	}
+
+	return tile, nil
+}
+
+func problemsTileQuery(z int) string {
+	attrs := "p.problem_id, p.type_id, p.importance"
+	if z >= problemsDetailZoom {
+		attrs += ", p.district_id, p.name, p.status"
+	}
+
+	return fmt.Sprintf(`
+		WITH bounds AS (
+			SELECT ST_TileEnvelope(?, ?, ?) AS geom
+		),
+		mvtgeom AS (
+			SELECT
+			ST_AsMVTGeom(ST_Transform(p.geom, 3857), bounds.geom) AS geom,
+			%s
+			FROM problems p, bounds
+			WHERE ST_Intersects(p.geom, ST_Transform(bounds.geom, 4326))
+		)
+		SELECT ST_AsMVT(mvtgeom.*, 'problems') FROM mvtgeom
+		`, attrs)
+}
+
+func districtsTileQuery(z int) string {
+	attrs := "d.district_id, d.name_ru"
+	if z >= districtsDetailZoom {
+		attrs += ", d.name_eng, d.reputation"
+	}
+
+	return fmt.Sprintf(`
+		WITH bounds AS (
+			SELECT ST_TileEnvelope(?, ?, ?) AS geom
+		),
+		mvtgeom AS (
+			SELECT
+			ST_AsMVTGeom(
+				ST_SimplifyPreserveTopology(ST_Transform(d.geom, 3857), (ST_XMax(bounds.geom) - ST_XMin(bounds.geom)) / 4096),
+				bounds.geom
+			) AS geom,
+			%s
+			FROM districts d, bounds
+			WHERE ST_Intersects(d.geom, ST_Transform(bounds.geom, 4326))
+		)
+		SELECT ST_AsMVT(mvtgeom.*, 'districts') FROM mvtgeom
+		`, attrs)
+}
@@ -0,0 +1,216 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/rwrrioe/geomap/backend/pkg/entities"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// WebhookDeliveryFilter narrows the delivery log, zero fields match everything
+type WebhookDeliveryFilter struct {
+	WebhookID int
+	Status    string
+	Limit     int
+}
+
+type WebhookRepository interface {
+	AddWebhook(ctx context.Context, webhook *entities.WebhookSubscription) error
+	GetWebhook(ctx context.Context, id int) (*entities.WebhookSubscription, error)
+	ListWebhooks(ctx context.Context, activeOnly bool) ([]entities.WebhookSubscription, error)
+	UpdateWebhook(ctx context.Context, webhook *entities.WebhookSubscription) error
+	DeleteWebhook(ctx context.Context, id int) error
+	AddWebhookDeliveries(ctx context.Context, deliveries []entities.WebhookDelivery) error
+	ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]entities.WebhookDelivery, error)
+	SaveWebhookDelivery(ctx context.Context, delivery *entities.WebhookDelivery) error
+	ListWebhookDeliveries(ctx context.Context, filter WebhookDeliveryFilter) ([]entities.WebhookDelivery, error)
+	RetryWebhookDelivery(ctx context.Context, id int64) (*entities.WebhookDelivery, error)
+	PruneWebhookDeliveries(ctx context.Context, before time.Time) (int64, error)
+}
+
+func (p *ProblemRepo) AddWebhook(ctx context.Context, webhook *entities.WebhookSubscription) error {
+	if err := p.Db.WithContext(ctx).Create(webhook).Error; err != nil {
+		return fmt.Errorf("db query failed: %w", err)
+	}
+
+	return nil
+}
+
+func (p *ProblemRepo) GetWebhook(ctx context.Context, id int) (*entities.WebhookSubscription, error) {
+	var webhook entities.WebhookSubscription
+
+	result := p.Db.WithContext(ctx).First(&webhook, id)
+	if result.Error != nil {
+		return nil, fmt.Errorf("db query failed: %w", result.Error)
+	}
+
+	return &webhook, nil
+}
+
+func (p *ProblemRepo) ListWebhooks(ctx context.Context, activeOnly bool) ([]entities.WebhookSubscription, error) {
+	var webhooks []entities.WebhookSubscription
+
+	db := p.Db.WithContext(ctx).Order("webhook_id")
+	if activeOnly {
+		db = db.Where("active")
+	}
+
+	if err := db.Find(&webhooks).Error; err != nil {
+		return nil, fmt.Errorf("db query failed: %w", err)
+	}
+
+	return webhooks, nil
+}
+
+// UpdateWebhook stores every settable field, ErrRecordNotFound when the webhook does not exist
+func (p *ProblemRepo) UpdateWebhook(ctx context.Context, webhook *entities.WebhookSubscription) error {
+	result := p.Db.WithContext(ctx).Model(webhook).Select(
+		"url", "secret", "event_types", "district_ids", "type_ids", "active", "updated_at",
+	).Updates(webhook)
+	if result.Error != nil {
+		return fmt.Errorf("db query failed: %w", result.Error)
+	}
+
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("db query failed: %w", gorm.ErrRecordNotFound)
+	}
+
+	return nil
+}
+
+// DeleteWebhook removes the webhook together with its delivery log
+func (p *ProblemRepo) DeleteWebhook(ctx context.Context, id int) error {
+	return p.Db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("webhook_id = ?", id).Delete(&entities.WebhookDelivery{}).Error; err != nil {
+			return fmt.Errorf("db query failed: %w", err)
+		}
+
+		result := tx.Delete(&entities.WebhookSubscription{}, id)
+		if result.Error != nil {
+			return fmt.Errorf("db query failed: %w", result.Error)
+		}
+
+		if result.RowsAffected == 0 {
+			return fmt.Errorf("db query failed: %w", gorm.ErrRecordNotFound)
+		}
+
+		return nil
+	})
+}
+
+// AddWebhookDeliveries queues deliveries, an event already queued for a webhook is skipped
+// so the outbox delivering an event twice does not send it twice
+func (p *ProblemRepo) AddWebhookDeliveries(ctx context.Context, deliveries []entities.WebhookDelivery) error {
+	if len(deliveries) == 0 {
+		return nil
+	}
+
+	result := p.Db.WithContext(ctx).
+		Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&deliveries)
+	if result.Error != nil {
+		return fmt.Errorf("db query failed: %w", result.Error)
+	}
+
+	return nil
+}
+
+// ClaimWebhookDeliveries leases pending deliveries due for an attempt, oldest first
+func (p *ProblemRepo) ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]entities.WebhookDelivery, error) {
+	var deliveries []entities.WebhookDelivery
+
+	result := p.Db.WithContext(ctx).Raw(
+		`
+		UPDATE webhook_deliveries
+		SET next_attempt_at = now() + ? * interval '1 millisecond'
+		WHERE delivery_id IN (
+			SELECT delivery_id
+			FROM webhook_deliveries
+			WHERE status = ?
+			AND next_attempt_at <= now()
+			ORDER BY delivery_id
+			LIMIT ?
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING *
+		`, lease.Milliseconds(), entities.WebhookDeliveryPending, limit).Scan(&deliveries)
+	if result.Error != nil {
+		return nil, fmt.Errorf("db query failed: %w", result.Error)
+	}
+
+	// RETURNING has no order
+	sort.Slice(deliveries, func(i, j int) bool { return deliveries[i].DeliveryID < deliveries[j].DeliveryID })
+
+	return deliveries, nil
+}
+
+// SaveWebhookDelivery records the outcome of an attempt
+func (p *ProblemRepo) SaveWebhookDelivery(ctx context.Context, delivery *entities.WebhookDelivery) error {
+	result := p.Db.WithContext(ctx).Model(delivery).Select(
+		"status", "attempts", "response_status", "last_error", "next_attempt_at", "delivered_at",
+	).Updates(delivery)
+	if result.Error != nil {
+		return fmt.Errorf("db query failed: %w", result.Error)
+	}
+
+	return nil
+}
+
+// ListWebhookDeliveries returns the newest deliveries first
+func (p *ProblemRepo) ListWebhookDeliveries(ctx context.Context, filter WebhookDeliveryFilter) ([]entities.WebhookDelivery, error) {
+	var deliveries []entities.WebhookDelivery
+
+	db := p.Db.WithContext(ctx).Order("delivery_id DESC").Limit(filter.Limit)
+	if filter.WebhookID != 0 {
+		db = db.Where("webhook_id = ?", filter.WebhookID)
+	}
+
+	if filter.Status != "" {
+		db = db.Where("status = ?", filter.Status)
+	}
+
+	if err := db.Find(&deliveries).Error; err != nil {
+		return nil, fmt.Errorf("db query failed: %w", err)
+	}
+
+	return deliveries, nil
+}
+
+// RetryWebhookDelivery queues a finished delivery again with a fresh set of attempts
+func (p *ProblemRepo) RetryWebhookDelivery(ctx context.Context, id int64) (*entities.WebhookDelivery, error) {
+	var delivery entities.WebhookDelivery
+
+	result := p.Db.WithContext(ctx).Raw(
+		`
+		UPDATE webhook_deliveries
+		SET status = ?, attempts = 0, last_error = '', next_attempt_at = now(), delivered_at = NULL
+		WHERE delivery_id = ?
+		RETURNING *
+		`, entities.WebhookDeliveryPending, id).Scan(&delivery)
+	if result.Error != nil {
+		return nil, fmt.Errorf("db query failed: %w", result.Error)
+	}
+
+	if result.RowsAffected == 0 {
+		return nil, fmt.Errorf("db query failed: %w", gorm.ErrRecordNotFound)
+	}
+
+	return &delivery, nil
+}
+
+// PruneWebhookDeliveries deletes deliveries that succeeded before the cutoff, dead ones stay
+// in the dead letter list until they are retried or the webhook is deleted
+func (p *ProblemRepo) PruneWebhookDeliveries(ctx context.Context, before time.Time) (int64, error) {
+	result := p.Db.WithContext(ctx).
+		Where("status = ? AND delivered_at < ?", entities.WebhookDeliveryDelivered, before).
+		Delete(&entities.WebhookDelivery{})
+	if result.Error != nil {
+		return 0, fmt.Errorf("db query failed: %w", result.Error)
+	}
+
+	return result.RowsAffected, nil
+}
@@ -21,14 +21,18 @@ type AIPredictService struct {
 	mu          sync.RWMutex
 	processed   map[int]bool
 	processing  map[int]bool
+	hotspots    *HotspotService
+	pois        *POIService
 }
 
-func NewAIPredictService(problemRepo repository.ProblemRepository) *AIPredictService {
+func NewAIPredictService(problemRepo repository.ProblemRepository, hotspots *HotspotService, pois *POIService) *AIPredictService {
 	return &AIPredictService{
 		problemRepo: problemRepo,
 		predicts:    make(map[int]*entities.BreefAIResponse),
 		processed:   make(map[int]bool),
 		processing:  make(map[int]bool),
+		hotspots:    hotspots,
+		pois:        pois,
 	}
 }
 
@@ -98,6 +102,7 @@ func (s *AIPredictService) PredictForDistrict(ctx context.Context, districtID in
 	if err != nil {
 		return err
 	}
+	exposure := s.promptExposure(ctx, districtID)
 	client, err := InitAI(ctx)
 	if err != nil {
 		return err
@@ -120,8 +125,9 @@ func (s *AIPredictService) PredictForDistrict(ctx context.Context, districtID in
 imp_avg - среднее по шкале важности проблем в данном районе(от 1 до 10). Во втором наборе данных усредненные данные по всему городу: problem_count - число проблем во всем городе, status_count - число решенных проблем во всем городе, imp_avg - среднее важности проблем по всему городу(от 1 до 10)
 Ты должен интерпретировать эти данные, 
 сделать анализ обощить статистику, сравнить со значениями по городу.Ты должен сделать будущие конкретные прогнозы для данного района основанные на типах возникаемых проблем и их частотею Сделай 4-5 содержательных предложений.
+Третий набор данных - нерешенные проблемы района рядом с социально значимыми объектами: problem_id, name, type_id, importance, schools, hospitals, clinics, bus_stops - число школ, больниц, поликлиник и остановок в радиусе рядом с проблемой. Выдели проблемы, которые затрагивают детей, пациентов и пассажиров, и учти их в приоритетах.
 Строго следуй конфигу и структуре не добавляй лишних комментариев. Статистика ниже. 
-`, districtStat, cityStat)
+`, districtStat, cityStat, exposurePromptData(exposure))
 
 	result, err := client.Models.GenerateContent(
 		ctx,
@@ -157,6 +163,7 @@ func (s *AIPredictService) PredictForType(ctx context.Context, typeID int) error
 	if err != nil {
 		return err
 	}
+	hotspots := s.promptHotspots(ctx, HotspotQuery{Filter: repository.ProblemFilter{TypeID: &typeID}})
 
 	client, err := InitAI(ctx)
 	if err != nil {
@@ -180,8 +187,9 @@ func (s *AIPredictService) PredictForType(ctx context.Context, typeID int) error
 imp_avg - среднее по шкале важности проблем в данном районе(от 1 до 10). Во втором наборе данных усредненные данные по всему городу: problem_count - число проблем во всем городе, status_count - число решенных проблем во всем городе, imp_avg - среднее важности проблем по всему городу(от 1 до 10)
 Ты должен интерпретировать эти данные, 
 сделать анализ обощить статистику, указав критические районы с данным типом проблем.Ты должен делать будущие конкретные прогнозы на основе типа проблемы и сравнения с данными по городу. Сделай 4-5 содержательных предложений.
+Третий набор данных - статистически значимые горячие и холодные точки (Getis-Ord Gi*) для данного типа: lon, lat - центр ячейки, class - hot/cold, confidence - уровень доверия в процентах, z_score, problem_count - число проблем в ячейке.
 Строго следуй конфигу и структуре не добавляй лишних комментариев. Статистика ниже. 
-`, typeStat, cityStat)
+`, typeStat, cityStat, hotspotsPromptData(hotspots))
 
 	result, err := client.Models.GenerateContent(
 		ctx,
@@ -206,12 +214,34 @@ imp_avg - среднее по шкале важности проблем в да
 	return nil
 }
 
+// promptHotspots detects hotspots for a prompt, the analysis goes on without them when detection fails
+func (s *AIPredictService) promptHotspots(ctx context.Context, q HotspotQuery) []Hotspot {
+	hotspots, err := s.hotspots.Detect(ctx, q)
+	if err != nil {
+		log.Println("hotspot detection failed, analysing without hotspots:", err)
+		return nil
+	}
+	return hotspots
+}
+
+// promptExposure lists the problems near sensitive pois for a prompt, the analysis goes on
+// without them when the lookup fails
+func (s *AIPredictService) promptExposure(ctx context.Context, districtID int) []repository.ProblemPOIExposure {
+	exposure, err := s.pois.DistrictExposure(ctx, districtID)
+	if err != nil {
+		log.Println("poi exposure lookup failed, analysing without pois:", err)
+		return nil
+	}
+	return exposure
+}
+
 func (s *AIPredictService) PredictForCity(ctx context.Context) error {
 	var extendedAIAnswer entities.ExtendedAIResponse
 	cityStat, err := s.problemRepo.GetAnalysisByCity(ctx)
 	if err != nil {
 		return err
 	}
+	hotspots := s.promptHotspots(ctx, HotspotQuery{})
 
 	client, err := InitAI(ctx)
 	if err != nil {
@@ -238,8 +268,9 @@ func (s *AIPredictService) PredictForCity(ctx context.Context) error {
 3)Сделать будущие конкретные прогнозы и риски на основе текущих данных
 4) найти решения.
 Выделить конкретный план действий и районы.Сделай 4-5 содержательных предложений.
+Второй набор данных - статистически значимые горячие и холодные точки (Getis-Ord Gi*): lon, lat - центр ячейки, class - hot/cold, confidence - уровень доверия в процентах, z_score, problem_count - число проблем в ячейке.
 Строго следуй конфигу и структуре не добавляй лишних комментариев. Статистика ниже. :
-`, cityStat)
+`, cityStat, hotspotsPromptData(hotspots))
 
 	result, err := client.Models.GenerateContent(
 		ctx,
@@ -265,6 +296,56 @@ func (s *AIPredictService) PredictForCity(ctx context.Context) error {
 	return nil
 }
 
+// NarrateArea describes the stats of a custom polygon, areas are ad hoc so the answer is not cached
+func (s *AIPredictService) NarrateArea(ctx context.Context, areaStat []repository.ProblemStatByDistrict, areaSize float64) (*entities.ExtendedAIResponse, error) {
+	var extendedAIAnswer entities.ExtendedAIResponse
+	cityStat, err := s.problemRepo.GetAnalysisByCity(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := InitAI(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &genai.GenerateContentConfig{
+		ResponseMIMEType: "application/json",
+		ResponseSchema: &genai.Schema{
+			Type: genai.TypeObject,
+			Properties: map[string]*genai.Schema{
+				"extended_answer": {Type: genai.TypeString},
+				"status":          {Type: genai.TypeString},
+			},
+			Required: []string{"extended_answer", "status"},
+		},
+	}
+
+	prompt := fmt.Sprint(`
+У тебя есть анализ по средним значениям и проблемам в городе Алматы по одной выбранной аналитиком территории (например, новый жилой комплекс), это не район города. В первом наборе данных type_id - айди типа проблемы, type_name - тип проблемы, problems_count - число проблем на территории, solved_count - число решенных проблем на территории, 
+imp_avg - среднее по шкале важности проблем на территории(от 1 до 10). Во втором наборе данных усредненные данные по всему городу: problem_count - число проблем во всем городе, status_count - число решенных проблем во всем городе, imp_avg - среднее важности проблем по всему городу(от 1 до 10). Третье значение - площадь территории в квадратных метрах.
+Ты должен интерпретировать эти данные, 
+сделать анализ обощить статистику с учетом площади, сравнить со значениями по городу.Ты должен сделать будущие конкретные прогнозы для данной территории основанные на типах возникаемых проблем и их частоте. Сделай 4-5 содержательных предложений.
+Строго следуй конфигу и структуре не добавляй лишних комментариев. Статистика ниже. 
+`, areaStat, cityStat, areaSize)
+
+	result, err := client.Models.GenerateContent(
+		ctx,
+		"gemini-2.5-flash",
+		genai.Text(prompt),
+		config,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate AI response:%w", err)
+	}
+
+	if err := json.Unmarshal([]byte(result.Text()), &extendedAIAnswer); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal AI response:%w", err)
+	}
+
+	return &extendedAIAnswer, nil
+}
+
 func (s *AIPredictService) PopAnalysis(ctx context.Context, id int) (*entities.BreefAIResponse, error) {
 	var breefAIAnswer entities.BreefAIResponse
 	districtStat, err := s.problemRepo.GetAnalysisByDistrict(ctx, id)
@@ -275,6 +356,7 @@ func (s *AIPredictService) PopAnalysis(ctx context.Context, id int) (*entities.B
 	if err != nil {
 		return nil, err
 	}
+	exposure := s.promptExposure(ctx, id)
 	client, err := InitAI(ctx)
 	if err != nil {
 		return nil, err
@@ -295,7 +377,8 @@ func (s *AIPredictService) PopAnalysis(ctx context.Context, id int) (*entities.B
 У тебя есть анализ по средним значениям и проблемам в городе Алматы по двум данным району. В первом наборе данных  type_id - айди типа проблемы, type_name - тип проблемы, problems_count - число проблем в данном районе, solved_count - число решенных проблем в данном районе, 
 imp_avg - среднее по шкале важности проблем в данном районе(от 1 до 10). Во втором наборе данных усредненные данные по всему городу: problem_count - число проблем во всем городе, status_count - число решенных проблем во всем городе, imp_avg - среднее важности проблем по всему городу(от 1 до 10)
 Ты должен интерпретировать эти данные. Ты должен написать 3-4 слова, буквально "ожидается:...", должен указать конкретный ожидаемый тип проблем и важность.Это будет вспылывающая надпись на карте проблем города. Она должна быть максимально краткой и содержательной 
-`, districtStat, cityStat)
+Третий набор данных - нерешенные проблемы рядом со школами, больницами, поликлиниками и остановками (schools, hospitals, clinics, bus_stops), если они есть, упомяни такой объект.
+`, districtStat, cityStat, exposurePromptData(exposure))
 
 	result, err := client.Models.GenerateContent(
 		ctx,
@@ -409,3 +492,38 @@ func (s *AIPredictService) GetAnalysisByType(ctx context.Context, typeID int) (*
 
 	return &analysisDTO, nil
 }
+
+// RegenerateAnalysis refreshes the cached city and district analyses, a failed district
+// does not stop the others
+func (s *AIPredictService) RegenerateAnalysis(ctx context.Context) (string, error) {
+	// InitAI exits the process without a key, a background job must not
+	if os.Getenv("GEMINI_API_KEY") == "" {
+		return "", fmt.Errorf("GEMINI_API_KEY is not set")
+	}
+
+	if err := s.PredictForCity(ctx); err != nil {
+		return "", err
+	}
+
+	ids, err := s.problemRepo.ListDistrictIDs(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	var failed int
+	var lastErr error
+	for _, id := range ids {
+		if err := s.PredictForDistrict(ctx, id); err != nil {
+			failed++
+			lastErr = err
+			log.Printf("district %d analysis failed: %v", id, err)
+		}
+	}
+
+	output := fmt.Sprintf("city and %d of %d districts regenerated", len(ids)-failed, len(ids))
+	if lastErr != nil {
+		return output, fmt.Errorf("%d districts failed, last error: %w", failed, lastErr)
+	}
+
+	return output, nil
+}
@@ -0,0 +1,116 @@
+package service
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/rwrrioe/geomap/backend/pkg/entities"
+	"github.com/rwrrioe/geomap/backend/pkg/repository"
+	"github.com/twpayne/go-geom"
+	"github.com/twpayne/go-geom/encoding/geojson"
+)
+
+type AreaQuery struct {
+	Polygon   string // geojson Polygon or MultiPolygon
+	SRID      int
+	Narrative bool
+}
+
+type AreaAnalysis struct {
+	AreaSize     float64                            `json:"area_sq_m"`
+	ProblemCount int                                `json:"problems_count"`
+	SolvedCount  int                                `json:"solved_count"`
+	Types        []repository.ProblemStatByDistrict `json:"types"`
+	Narrative    *entities.ExtendedAIResponse       `json:"narrative,omitempty"`
+}
+
+type AreaService struct {
+	repo repository.ProblemRepository
+	crs  *CRSService
+	ai   *AIPredictService
+}
+
+func NewAreaService(repo repository.ProblemRepository, crs *CRSService, ai *AIPredictService) *AreaService {
+	return &AreaService{
+		repo: repo,
+		crs:  crs,
+		ai:   ai,
+	}
+}
+
+func (s *AreaService) parsePolygon(ctx context.Context, raw string, srid int) (geom.T, error) {
+	if raw == "" {
+		return nil, fmt.Errorf("polygon is required")
+	}
+
+	var g geom.T
+	if err := geojson.Unmarshal([]byte(raw), &g); err != nil {
+		return nil, fmt.Errorf("invalid polygon: %w", err)
+	}
+
+	switch g.(type) {
+	case *geom.Polygon, *geom.MultiPolygon:
+	default:
+		return nil, fmt.Errorf("area must be a Polygon or MultiPolygon")
+	}
+
+	if g.Layout() != geom.XY {
+		return nil, fmt.Errorf("polygon must be two-dimensional")
+	}
+
+	if srid == 0 {
+		srid = repository.SRIDWGS84
+	}
+
+	g, err := s.crs.ToWGS84(ctx, g, srid)
+	if err != nil {
+		return nil, err
+	}
+
+	reason, err := s.repo.GeometryInvalidReason(ctx, g)
+	if err != nil {
+		return nil, err
+	}
+	if reason != "" {
+		return nil, fmt.Errorf("invalid polygon: %s", reason)
+	}
+
+	return g, nil
+}
+
+// Analyze breaks down the problems inside the polygon per type, like the district analysis
+func (s *AreaService) Analyze(ctx context.Context, q AreaQuery) (*AreaAnalysis, error) {
+	area, err := s.parsePolygon(ctx, q.Polygon, q.SRID)
+	if err != nil {
+		return nil, err
+	}
+
+	stats, err := s.repo.GetAnalysisByArea(ctx, area)
+	if err != nil {
+		return nil, err
+	}
+
+	size, err := s.repo.AreaSize(ctx, area)
+	if err != nil {
+		return nil, err
+	}
+
+	analysis := &AreaAnalysis{
+		AreaSize: size,
+		Types:    make([]repository.ProblemStatByDistrict, 0, len(stats)),
+	}
+	analysis.Types = append(analysis.Types, stats...)
+	for _, st := range stats {
+		analysis.ProblemCount += st.ProblemCount
+		analysis.SolvedCount += st.SolvedCount
+	}
+
+	if q.Narrative && analysis.ProblemCount > 0 {
+		analysis.Narrative, err = s.ai.NarrateArea(ctx, stats, size)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return analysis, nil
+}
@@ -0,0 +1,216 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"math"
+
+	"github.com/rwrrioe/geomap/backend/pkg/repository"
+	"github.com/twpayne/go-geom"
+	"github.com/twpayne/go-geom/encoding/geojson"
+)
+
+const (
+	maxCorridorBuffer     = 1000
+	defaultSegmentLength  = 500
+	minSegmentLength      = 50
+	maxCorridorSegments   = 200
+	earthRadiusMeters     = 6371008.8
+	polylinePrecisionBase = 1e5
+)
+
+type CorridorQuery struct {
+	Line          string // geojson LineString
+	Polyline      string // encoded polyline, used when Line is empty
+	SRID          int    // crs of Line, encoded polylines are always WGS84
+	Buffer        float64
+	SegmentLength float64
+}
+
+type CorridorSegment struct {
+	Index         int         `json:"index"`
+	From          float64     `json:"from"`
+	To            float64     `json:"to"`
+	ProblemCount  int         `json:"problem_count"`
+	SolvedCount   int         `json:"solved_count"`
+	ImportanceAvg float64     `json:"importance_avg"`
+	Types         map[int]int `json:"types"`
+	importanceSum float64
+}
+
+type CorridorResult struct {
+	Length   float64                      `json:"length"`
+	Buffer   float64                      `json:"buffer"`
+	Problems []repository.CorridorProblem `json:"problems"`
+	Segments []CorridorSegment            `json:"segments"`
+}
+
+type CorridorService struct {
+	repo repository.ProblemRepository
+	crs  *CRSService
+}
+
+func NewCorridorService(repo repository.ProblemRepository, crs *CRSService) *CorridorService {
+	return &CorridorService{
+		repo: repo,
+		crs:  crs,
+	}
+}
+
+func (s *CorridorService) ProblemsAlongRoute(ctx context.Context, q CorridorQuery) (*CorridorResult, error) {
+	if q.Buffer <= 0 || q.Buffer > maxCorridorBuffer {
+		return nil, fmt.Errorf("buffer must be between 0 and %d meters", maxCorridorBuffer)
+	}
+
+	if q.SegmentLength == 0 {
+		q.SegmentLength = defaultSegmentLength
+	}
+
+	if q.SegmentLength < minSegmentLength {
+		return nil, fmt.Errorf("segment length must be at least %d meters", minSegmentLength)
+	}
+
+	line, err := s.parseLine(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+
+	length := lineLength(line)
+	segmentCount := int(math.Ceil(length / q.SegmentLength))
+	if segmentCount > maxCorridorSegments {
+		return nil, fmt.Errorf("route splits into more than %d segments, increase segment length", maxCorridorSegments)
+	}
+
+	problems, err := s.repo.ListProblemsAlongLine(ctx, line, q.Buffer)
+	if err != nil {
+		return nil, err
+	}
+
+	segments := make([]CorridorSegment, segmentCount)
+	for i := range segments {
+		segments[i] = CorridorSegment{
+			Index: i,
+			From:  float64(i) * q.SegmentLength,
+			To:    math.Min(float64(i+1)*q.SegmentLength, length),
+			Types: make(map[int]int),
+		}
+	}
+
+	for i := range problems {
+		// fraction is the share of the ground length, so it scales the haversine length as well
+		problems[i].Along = problems[i].Fraction * length
+		if segmentCount == 0 {
+			continue
+		}
+
+		idx := min(int(problems[i].Along/q.SegmentLength), segmentCount-1)
+		seg := &segments[idx]
+		seg.ProblemCount++
+		seg.importanceSum += problems[i].Importance
+		seg.Types[problems[i].TypeID]++
+		if problems[i].Status == "solved" {
+			seg.SolvedCount++
+		}
+	}
+
+	for i := range segments {
+		if segments[i].ProblemCount > 0 {
+			segments[i].ImportanceAvg = math.Round(segments[i].importanceSum/float64(segments[i].ProblemCount)*100) / 100
+		}
+	}
+
+	return &CorridorResult{
+		Length:   length,
+		Buffer:   q.Buffer,
+		Problems: problems,
+		Segments: segments,
+	}, nil
+}
+
+func (s *CorridorService) parseLine(ctx context.Context, q CorridorQuery) (*geom.LineString, error) {
+	var g geom.T
+	srid := q.SRID
+
+	switch {
+	case q.Line != "":
+		if err := geojson.Unmarshal([]byte(q.Line), &g); err != nil {
+			return nil, fmt.Errorf("invalid line: %w", err)
+		}
+	case q.Polyline != "":
+		decoded, err := decodePolyline(q.Polyline)
+		if err != nil {
+			return nil, err
+		}
+		g = decoded
+		srid = repository.SRIDWGS84
+	default:
+		return nil, fmt.Errorf("line or polyline is required")
+	}
+
+	if srid == 0 {
+		srid = repository.SRIDWGS84
+	}
+
+	line, ok := g.(*geom.LineString)
+	if !ok || line.NumCoords() < 2 {
+		return nil, fmt.Errorf("route must be a LineString with at least two points")
+	}
+
+	transformed, err := s.crs.ToWGS84(ctx, line, srid)
+	if err != nil {
+		return nil, err
+	}
+
+	return transformed.(*geom.LineString), nil
+}
+
+// decodePolyline decodes the google encoded polyline format with precision 5
+func decodePolyline(encoded string) (*geom.LineString, error) {
+	var flat []float64
+	var lat, lon int
+
+	for i := 0; i < len(encoded); {
+		var deltas [2]int
+		for d := range deltas {
+			var result, shift int
+			for {
+				if i >= len(encoded) {
+					return nil, fmt.Errorf("invalid polyline")
+				}
+				b := int(encoded[i]) - 63
+				i++
+				result |= (b & 0x1f) << shift
+				shift += 5
+				if b < 0x20 {
+					break
+				}
+			}
+			if result&1 != 0 {
+				deltas[d] = ^(result >> 1)
+			} else {
+				deltas[d] = result >> 1
+			}
+		}
+
+		lat += deltas[0]
+		lon += deltas[1]
+		flat = append(flat, float64(lon)/polylinePrecisionBase, float64(lat)/polylinePrecisionBase)
+	}
+
+	return geom.NewLineStringFlat(geom.XY, flat), nil
+}
+
+// lineLength is the haversine length of a WGS84 line in meters
+func lineLength(line *geom.LineString) float64 {
+	var length float64
+	for i := 1; i < line.NumCoords(); i++ {
+		a, b := line.Coord(i-1), line.Coord(i)
+		lat1, lat2 := a.Y()*math.Pi/180, b.Y()*math.Pi/180
+		dLat := lat2 - lat1
+		dLon := (b.X() - a.X()) * math.Pi / 180
+
+		h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+		length += 2 * earthRadiusMeters * math.Asin(math.Sqrt(h))
+	}
+	return length
+}
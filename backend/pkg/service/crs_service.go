@@ -0,0 +1,108 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/rwrrioe/geomap/backend/pkg/entities"
+	"github.com/rwrrioe/geomap/backend/pkg/repository"
+	"github.com/twpayne/go-geom"
+)
+
+// CRSService converts input coordinates to WGS84 and keeps them inside the city envelope
+type CRSService struct {
+	repo     repository.ProblemRepository
+	envelope entities.BBox
+}
+
+func NewCRSService(repo repository.ProblemRepository, envelope entities.BBox) *CRSService {
+	return &CRSService{
+		repo:     repo,
+		envelope: envelope,
+	}
+}
+
+// ParseSRID accepts "EPSG:3857" or "3857", empty input means WGS84
+func (s *CRSService) ParseSRID(ctx context.Context, raw string) (int, error) {
+	if raw == "" {
+		return repository.SRIDWGS84, nil
+	}
+
+	srid, err := strconv.Atoi(strings.TrimPrefix(strings.ToUpper(raw), "EPSG:"))
+	if err != nil {
+		return 0, fmt.Errorf("invalid crs %q", raw)
+	}
+
+	if srid != repository.SRIDWGS84 && !s.repo.IsKnownSRID(ctx, srid) {
+		return 0, fmt.Errorf("unsupported crs %q", raw)
+	}
+
+	return srid, nil
+}
+
+// ToWGS84 reprojects g when needed and rejects geometries leaving the city envelope
+func (s *CRSService) ToWGS84(ctx context.Context, g geom.T, srid int) (geom.T, error) {
+	if srid != repository.SRIDWGS84 {
+		transformed, err := s.repo.TransformToWGS84(ctx, g, srid)
+		if err != nil {
+			return nil, err
+		}
+		g = transformed
+	}
+
+	if err := s.validate(g); err != nil {
+		return nil, err
+	}
+
+	return g, nil
+}
+
+func (s *CRSService) ToWGS84Point(ctx context.Context, x, y float64, srid int) (lon, lat float64, err error) {
+	g, err := s.ToWGS84(ctx, geom.NewPointFlat(geom.XY, []float64{x, y}), srid)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	point := g.(*geom.Point)
+	return point.X(), point.Y(), nil
+}
+
+// ToWGS84BBox reprojects both corners, the bbox itself may extend past the city
+func (s *CRSService) ToWGS84BBox(ctx context.Context, bbox entities.BBox, srid int) (entities.BBox, error) {
+	if srid == repository.SRIDWGS84 {
+		return bbox, nil
+	}
+
+	corners := geom.NewLineStringFlat(geom.XY, []float64{bbox.MinLon, bbox.MinLat, bbox.MaxLon, bbox.MaxLat})
+	g, err := s.repo.TransformToWGS84(ctx, corners, srid)
+	if err != nil {
+		return entities.BBox{}, err
+	}
+
+	coords := g.FlatCoords()
+	return entities.BBox{
+		MinLon: coords[0],
+		MinLat: coords[1],
+		MaxLon: coords[2],
+		MaxLat: coords[3],
+	}, nil
+}
+
+func (s *CRSService) validate(g geom.T) error {
+	coords := g.FlatCoords()
+	for i := 0; i+1 < len(coords); i += g.Stride() {
+		lon, lat := coords[i], coords[i+1]
+		if s.envelope.Contains(lon, lat) {
+			continue
+		}
+
+		if s.envelope.Contains(lat, lon) {
+			return fmt.Errorf("coordinate (%g, %g) is outside the city, latitude and longitude look swapped", lon, lat)
+		}
+		return fmt.Errorf("coordinate (%g, %g) is outside the city", lon, lat)
+	}
+
+	return nil
+}
@@ -0,0 +1,262 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"math"
+
+	"github.com/rwrrioe/geomap/backend/pkg/entities"
+	"github.com/rwrrioe/geomap/backend/pkg/repository"
+	"gorm.io/gorm"
+)
+
+// simplification tolerance in degrees, 0.01 is roughly a kilometer in Almaty
+const maxSimplifyTolerance = 0.01
+
+type DistrictService struct {
+	repo repository.ProblemRepository
+}
+
+func NewDistrictService(repo repository.ProblemRepository) *DistrictService {
+	return &DistrictService{
+		repo: repo,
+	}
+}
+
+func (d *DistrictService) ListDistricts(ctx context.Context, tolerance float64, lang string) (*entities.GeoJSONFeatureCollection, error) {
+	if err := validateTolerance(tolerance); err != nil {
+		return nil, err
+	}
+
+	districts, err := d.repo.ListDistrictFeatures(ctx, tolerance, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	features := make([]entities.GeoJSONFeature, 0, len(districts))
+	for _, district := range districts {
+		features = append(features, newDistrictFeature(district, lang))
+	}
+
+	return entities.NewFeatureCollection(features), nil
+}
+
+func (d *DistrictService) GetDistrict(ctx context.Context, id int, tolerance float64, lang string) (*entities.GeoJSONFeature, error) {
+	if err := validateTolerance(tolerance); err != nil {
+		return nil, err
+	}
+
+	districts, err := d.repo.ListDistrictFeatures(ctx, tolerance, &id)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(districts) == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	feature := newDistrictFeature(districts[0], lang)
+	return &feature, nil
+}
+
+func validateTolerance(tolerance float64) error {
+	if tolerance < 0 || tolerance > maxSimplifyTolerance {
+		return fmt.Errorf("tolerance must be between 0 and %g", maxSimplifyTolerance)
+	}
+	return nil
+}
+
+func newDistrictFeature(d repository.DistrictFeature, lang string) entities.GeoJSONFeature {
+	name := d.NameRU
+	if lang == "en" && d.NameENG != "" {
+		name = d.NameENG
+	}
+
+	return entities.NewFeature(d.Geometry, entities.DistrictProperties{
+		DistrictID:   d.DistrictID,
+		Name:         name,
+		NameRU:       d.NameRU,
+		NameENG:      d.NameENG,
+		Reputation:   d.Reputation,
+		ProblemCount: d.ProblemCount,
+		OpenCount:    d.OpenCount,
+	})
+}
+
+// a type deviates from the neighbours when its count differs by deviationRatio
+// in either direction or its average importance by deviationImportance
+const (
+	deviationRatio      = 1.5
+	deviationImportance = 1.5
+	// ignore types too rare on both sides to compare
+	minDeviationCount = 3
+	// width of the band along shared borders in meters
+	defaultBorderBand = 500
+	maxBorderBand     = 3000
+)
+
+const (
+	DeviationHigher  = "higher"
+	DeviationLower   = "lower"
+	DeviationSimilar = "similar"
+)
+
+type NeighbourSummary struct {
+	repository.DistrictNeighbourDTO
+	ProblemCount int `json:"problems_count"`
+	// problems within the border band, on this district's side and on the neighbour's
+	BorderOwnSide       int `json:"border_own_side"`
+	BorderNeighbourSide int `json:"border_neighbour_side"`
+}
+
+type TypeComparison struct {
+	TypeID                 int     `json:"type_id"`
+	TypeName               string  `json:"type_name"`
+	ProblemCount           int     `json:"problems_count"`
+	SolvedCount            int     `json:"solved_count"`
+	ImpAvg                 float64 `json:"imp_avg"`
+	NeighbourCountAvg      float64 `json:"neighbour_count_avg"`
+	NeighbourSolvedRate    float64 `json:"neighbour_solved_rate"`
+	NeighbourImpAvg        float64 `json:"neighbour_imp_avg"`
+	CountDeviation         string  `json:"count_deviation"`
+	ImportanceDeviation    string  `json:"importance_deviation"`
+	neighbourCount         int
+	neighbourSolved        int
+	neighbourImportanceSum float64
+}
+
+type NeighbourComparison struct {
+	DistrictID int                `json:"district_id"`
+	BorderBand float64            `json:"border_band"`
+	Neighbours []NeighbourSummary `json:"neighbours"`
+	Types      []TypeComparison   `json:"types"`
+}
+
+// CompareWithNeighbours compares per type stats of a district with the average of its
+// adjacent districts and counts problems along each shared border
+func (d *DistrictService) CompareWithNeighbours(ctx context.Context, id int, band float64) (*NeighbourComparison, error) {
+	if !d.repo.IsDistrict(ctx, id) {
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	if band == 0 {
+		band = defaultBorderBand
+	}
+
+	if band < 0 || band > maxBorderBand {
+		return nil, fmt.Errorf("border band must be between 0 and %d meters", maxBorderBand)
+	}
+
+	neighbours, err := d.repo.ListNeighbours(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := []int{id}
+	for _, n := range neighbours {
+		ids = append(ids, n.DistrictID)
+	}
+
+	stats, err := d.repo.GetAnalysisByDistricts(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	borders, err := d.repo.CountBorderProblems(ctx, id, band)
+	if err != nil {
+		return nil, err
+	}
+
+	return compareWithNeighbours(id, band, neighbours, stats, borders), nil
+}
+
+func compareWithNeighbours(id int, band float64, neighbours []repository.DistrictNeighbourDTO,
+	stats []repository.DistrictTypeStat, borders []repository.BorderProblemCount) *NeighbourComparison {
+	summaries := make([]NeighbourSummary, len(neighbours))
+	summaryIndex := make(map[int]int, len(neighbours))
+	for i, n := range neighbours {
+		summaries[i] = NeighbourSummary{DistrictNeighbourDTO: n}
+		summaryIndex[n.DistrictID] = i
+	}
+
+	for _, b := range borders {
+		if i, ok := summaryIndex[b.NeighbourID]; ok {
+			summaries[i].BorderOwnSide = b.OwnSide
+			summaries[i].BorderNeighbourSide = b.NeighbourSide
+		}
+	}
+
+	types := make([]TypeComparison, 0)
+	typeIndex := make(map[int]int)
+	for _, st := range stats {
+		i, ok := typeIndex[st.TypeID]
+		if !ok {
+			i = len(types)
+			typeIndex[st.TypeID] = i
+			types = append(types, TypeComparison{TypeID: st.TypeID, TypeName: st.TypeName})
+		}
+
+		t := &types[i]
+		if st.DistrictID == id {
+			t.ProblemCount = st.ProblemCount
+			t.SolvedCount = st.SolvedCount
+			t.ImpAvg = st.ImpAvg
+			continue
+		}
+
+		if j, ok := summaryIndex[st.DistrictID]; ok {
+			summaries[j].ProblemCount += st.ProblemCount
+		}
+		t.neighbourCount += st.ProblemCount
+		t.neighbourSolved += st.SolvedCount
+		t.neighbourImportanceSum += st.ImpAvg * float64(st.ProblemCount)
+	}
+
+	for i := range types {
+		t := &types[i]
+		if len(neighbours) > 0 {
+			t.NeighbourCountAvg = round2(float64(t.neighbourCount) / float64(len(neighbours)))
+		}
+		if t.neighbourCount > 0 {
+			t.NeighbourSolvedRate = round2(float64(t.neighbourSolved) / float64(t.neighbourCount))
+			t.NeighbourImpAvg = round2(t.neighbourImportanceSum / float64(t.neighbourCount))
+		}
+
+		t.CountDeviation = countDeviation(float64(t.ProblemCount), t.NeighbourCountAvg)
+		t.ImportanceDeviation = DeviationSimilar
+		if t.ProblemCount > 0 && t.neighbourCount > 0 {
+			switch diff := t.ImpAvg - t.NeighbourImpAvg; {
+			case diff >= deviationImportance:
+				t.ImportanceDeviation = DeviationHigher
+			case diff <= -deviationImportance:
+				t.ImportanceDeviation = DeviationLower
+			}
+		}
+	}
+
+	return &NeighbourComparison{
+		DistrictID: id,
+		BorderBand: band,
+		Neighbours: summaries,
+		Types:      types,
+	}
+}
+
+func countDeviation(own, neighbourAvg float64) string {
+	if own < minDeviationCount && neighbourAvg < minDeviationCount {
+		return DeviationSimilar
+	}
+
+	switch {
+	case own >= neighbourAvg*deviationRatio:
+		return DeviationHigher
+	case own*deviationRatio <= neighbourAvg:
+		return DeviationLower
+	default:
+		return DeviationSimilar
+	}
+}
+
+func round2(v float64) float64 {
+	return math.Round(v*100) / 100
+}
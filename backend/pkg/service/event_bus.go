@@ -0,0 +1,282 @@
+package service
+
+import (
+	"context"
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/rwrrioe/geomap/backend/pkg/entities"
+	"github.com/rwrrioe/geomap/backend/pkg/repository"
+)
+
+const (
+	// events kept in memory for Last-Event-ID replay, older ones are read from the outbox
+	eventReplaySize = 1024
+	// a subscriber this far behind is dropped and has to reconnect with its last id
+	subscriberBuffer = 256
+
+	defaultEventPollInterval = time.Second
+	eventTailBatch           = 500
+	// a missing id is an insert not committed yet or rolled back, the tail waits this long
+	// for it before moving on, so events committed out of id order are not skipped
+	eventGapTimeout = 5 * time.Second
+	// events replayed from the outbox table at most, older resumes get a reset
+	maxTableReplay = 5000
+)
+
+// EventFilter narrows a subscription, empty fields match everything
+type EventFilter struct {
+	Types       []string
+	DistrictIDs []int
+	TypeIDs     []int
+}
+
+func (f EventFilter) match(e entities.ProblemEvent) bool {
+	if len(f.Types) > 0 && !slices.Contains(f.Types, e.Type) {
+		return false
+	}
+
+	if len(f.TypeIDs) > 0 && !slices.Contains(f.TypeIDs, e.TypeID) {
+		return false
+	}
+
+	if len(f.DistrictIDs) > 0 && !slices.Contains(f.DistrictIDs, e.DistrictID) &&
+		!slices.ContainsFunc(e.DistrictIDs, func(id int) bool { return slices.Contains(f.DistrictIDs, id) }) {
+		return false
+	}
+
+	return true
+}
+
+type Subscription struct {
+	filter EventFilter
+	// events up to this id reach the subscriber through the replay
+	after  int64
+	events chan entities.ProblemEvent
+}
+
+// Events is closed when the subscriber falls behind or unsubscribes
+func (s *Subscription) Events() <-chan entities.ProblemEvent {
+	return s.events
+}
+
+// EventBus fans problem events out to live subscribers in process. Every replica tails the
+// outbox table by event id, so subscribers see all events whichever replica they are connected
+// to, and event ids are outbox ids a client can resume from on any replica.
+type EventBus struct {
+	repo     repository.ProblemRepository
+	interval time.Duration
+
+	mu sync.Mutex
+	// highest outbox id published
+	lastID   int64
+	gapSince time.Time
+	replay   []entities.ProblemEvent
+	subs     map[*Subscription]struct{}
+}
+
+func NewEventBus(repo repository.ProblemRepository, interval time.Duration) *EventBus {
+	if interval <= 0 {
+		interval = defaultEventPollInterval
+	}
+
+	return &EventBus{
+		repo:     repo,
+		interval: interval,
+		subs:     make(map[*Subscription]struct{}),
+	}
+}
+
+// Start tails the outbox from its newest event until ctx is done, older events are only replayed
+func (b *EventBus) Start(ctx context.Context) error {
+	_, last, err := b.repo.OutboxEventIDRange(ctx)
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	b.lastID = last
+	b.mu.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(b.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := b.poll(ctx); err != nil {
+					log.Println("event bus poll failed:", err)
+				}
+			}
+		}
+	}()
+
+	return nil
+}
+
+// poll publishes the events stored since the last one, batch by batch
+func (b *EventBus) poll(ctx context.Context) error {
+	for {
+		b.mu.Lock()
+		after := b.lastID
+		b.mu.Unlock()
+
+		events, err := b.repo.ListOutboxEventsAfter(ctx, after, eventTailBatch)
+		if err != nil {
+			return err
+		}
+
+		if !b.publishBatch(events) || len(events) < eventTailBatch {
+			return nil
+		}
+	}
+}
+
+// publishBatch publishes events in id order and reports whether it got through all of them
+func (b *EventBus) publishBatch(events []entities.OutboxEvent) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	for _, row := range events {
+		if row.EventID != b.lastID+1 {
+			if b.gapSince.IsZero() {
+				b.gapSince = now
+			}
+			if now.Sub(b.gapSince) < eventGapTimeout {
+				return false
+			}
+		}
+		b.gapSince = time.Time{}
+
+		e := row.Payload
+		e.ID = row.EventID
+		b.publish(e)
+	}
+
+	return true
+}
+
+// publish must be called with mu held
+func (b *EventBus) publish(e entities.ProblemEvent) {
+	b.lastID = e.ID
+	if e.OccurredAt.IsZero() {
+		e.OccurredAt = time.Now()
+	}
+
+	if len(b.replay) == eventReplaySize {
+		b.replay = append(b.replay[:0], b.replay[1:]...)
+	}
+	b.replay = append(b.replay, e)
+
+	for sub := range b.subs {
+		if e.ID <= sub.after || !sub.filter.match(e) {
+			continue
+		}
+
+		select {
+		case sub.events <- e:
+		default:
+			delete(b.subs, sub)
+			close(sub.events)
+		}
+	}
+}
+
+// Subscribe registers a subscriber and returns the matching events after lastID, from memory
+// or from the outbox table. complete is false when events after lastID were already pruned
+// and the client should reload its state instead of relying on the replay.
+func (b *EventBus) Subscribe(ctx context.Context, filter EventFilter, lastID int64) (sub *Subscription, replay []entities.ProblemEvent, complete bool, err error) {
+	b.mu.Lock()
+	cursor := b.lastID
+	sub = &Subscription{
+		filter: filter,
+		after:  max(lastID, cursor),
+		events: make(chan entities.ProblemEvent, subscriberBuffer),
+	}
+	b.subs[sub] = struct{}{}
+
+	// a client ahead of this replica gets the rest live, sub.after filters what it has seen
+	if lastID == 0 || lastID >= cursor {
+		b.mu.Unlock()
+		return sub, nil, true, nil
+	}
+
+	if len(b.replay) > 0 && lastID >= b.replay[0].ID-1 {
+		for _, e := range b.replay {
+			if e.ID > lastID && filter.match(e) {
+				replay = append(replay, e)
+			}
+		}
+		b.mu.Unlock()
+		return sub, replay, true, nil
+	}
+	b.mu.Unlock()
+
+	replay, complete, err = b.tableReplay(ctx, filter, lastID, cursor)
+	if err != nil {
+		b.Unsubscribe(sub)
+		return nil, nil, false, err
+	}
+
+	return sub, replay, complete, nil
+}
+
+// tableReplay reads the matching events in (lastID, cursor] from the outbox table
+func (b *EventBus) tableReplay(ctx context.Context, filter EventFilter, lastID, cursor int64) ([]entities.ProblemEvent, bool, error) {
+	first, _, err := b.repo.OutboxEventIDRange(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if first == 0 || lastID < first-1 {
+		return nil, false, nil
+	}
+
+	var replay []entities.ProblemEvent
+	for after, read := lastID, 0; after < cursor; {
+		events, err := b.repo.ListOutboxEventsAfter(ctx, after, eventTailBatch)
+		if err != nil {
+			return nil, false, err
+		}
+
+		for _, row := range events {
+			if row.EventID > cursor {
+				return replay, true, nil
+			}
+
+			e := row.Payload
+			e.ID = row.EventID
+			if filter.match(e) {
+				replay = append(replay, e)
+			}
+			after = row.EventID
+		}
+
+		read += len(events)
+		if read > maxTableReplay {
+			return nil, false, nil
+		}
+
+		if len(events) < eventTailBatch {
+			break
+		}
+	}
+
+	return replay, true, nil
+}
+
+func (b *EventBus) Unsubscribe(sub *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.subs[sub]; ok {
+		delete(b.subs, sub)
+		close(sub.events)
+	}
+}
@@ -0,0 +1,93 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/rwrrioe/geomap/backend/pkg/repository"
+)
+
+const (
+	ExportCSV       = "csv"
+	ExportKML       = "kml"
+	ExportGPX       = "gpx"
+	ExportShapefile = "shp"
+)
+
+// problemExporter writes problems in one format, rows arrive one at a time
+type problemExporter interface {
+	begin() error
+	write(row *repository.ExportRow) error
+	end() error
+}
+
+type ExportFormat struct {
+	ContentType string
+	Extension   string
+	newExporter func(w io.Writer) problemExporter
+}
+
+var exportFormats = map[string]ExportFormat{
+	ExportCSV: {
+		ContentType: "text/csv; charset=utf-8",
+		Extension:   "csv",
+		newExporter: newCSVExporter,
+	},
+	ExportKML: {
+		ContentType: "application/vnd.google-earth.kml+xml",
+		Extension:   "kml",
+		newExporter: newKMLExporter,
+	},
+	ExportGPX: {
+		ContentType: "application/gpx+xml",
+		Extension:   "gpx",
+		newExporter: newGPXExporter,
+	},
+	ExportShapefile: {
+		ContentType: "application/zip",
+		Extension:   "zip",
+		newExporter: newShapefileExporter,
+	},
+}
+
+type ExportService struct {
+	repo repository.ProblemRepository
+}
+
+func NewExportService(repo repository.ProblemRepository) *ExportService {
+	return &ExportService{
+		repo: repo,
+	}
+}
+
+func (e *ExportService) Format(format string) (ExportFormat, error) {
+	f, ok := exportFormats[format]
+	if !ok {
+		return ExportFormat{}, fmt.Errorf("unknown export format %q", format)
+	}
+	return f, nil
+}
+
+// Export streams the filtered problems to w as they are read from the database
+func (e *ExportService) Export(ctx context.Context, format string, filter repository.ProblemFilter, w io.Writer) error {
+	f, err := e.Format(format)
+	if err != nil {
+		return err
+	}
+
+	exporter := f.newExporter(w)
+	if err := exporter.begin(); err != nil {
+		return err
+	}
+
+	if err := e.repo.StreamProblems(ctx, filter, exporter.write); err != nil {
+		// exporters holding temp files release them on failure
+		if c, ok := exporter.(interface{ close() }); ok {
+			c.close()
+		}
+		return err
+	}
+
+	return exporter.end()
+}
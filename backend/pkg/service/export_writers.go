@@ -0,0 +1,438 @@
+package service
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/jonas-p/go-shp"
+	"github.com/rwrrioe/geomap/backend/pkg/entities"
+	"github.com/rwrrioe/geomap/backend/pkg/repository"
+	"github.com/twpayne/go-geom"
+	"github.com/twpayne/go-geom/encoding/wkt"
+)
+
+// flush streamed formats every exportFlushRows rows
+const exportFlushRows = 500
+
+func formatCreatedAt(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+func formatCoord(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// CSV
+
+type csvExporter struct {
+	w    *csv.Writer
+	rows int
+}
+
+func newCSVExporter(w io.Writer) problemExporter {
+	return &csvExporter{w: csv.NewWriter(w)}
+}
+
+func (e *csvExporter) begin() error {
+	return e.w.Write([]string{
+		"problem_id", "name", "description", "type_id", "type", "status",
+		"importance", "district_id", "address", "created_at", "wkt",
+	})
+}
+
+func (e *csvExporter) write(row *repository.ExportRow) error {
+	err := e.w.Write([]string{
+		strconv.Itoa(row.ProblemID),
+		row.Name,
+		row.Description,
+		strconv.Itoa(row.TypeID),
+		entities.ProblemTypeMap[row.TypeID],
+		row.Status,
+		formatCoord(row.Importance),
+		strconv.Itoa(row.DistrictID),
+		row.Address,
+		formatCreatedAt(row.CreatedAt),
+		row.WKT,
+	})
+	if err != nil {
+		return err
+	}
+
+	e.rows++
+	if e.rows%exportFlushRows == 0 {
+		e.w.Flush()
+	}
+	return e.w.Error()
+}
+
+func (e *csvExporter) end() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// KML
+
+type kmlExporter struct {
+	w   io.Writer
+	enc *xml.Encoder
+}
+
+func newKMLExporter(w io.Writer) problemExporter {
+	return &kmlExporter{w: w, enc: xml.NewEncoder(w)}
+}
+
+func (e *kmlExporter) begin() error {
+	_, err := io.WriteString(e.w, xml.Header+`<kml xmlns="http://www.opengis.net/kml/2.2"><Document><name>problems</name>`)
+	return err
+}
+
+type kmlData struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value"`
+}
+
+type kmlPlacemark struct {
+	XMLName     xml.Name  `xml:"Placemark"`
+	Name        string    `xml:"name"`
+	Description string    `xml:"description,omitempty"`
+	Data        []kmlData `xml:"ExtendedData>Data"`
+	// embedded so Point, LineString or Polygon is a direct child of Placemark
+	kmlGeometry
+}
+
+type kmlGeometry struct {
+	Point      *kmlCoords  `xml:"Point,omitempty"`
+	LineString *kmlCoords  `xml:"LineString,omitempty"`
+	Polygon    *kmlPolygon `xml:"Polygon,omitempty"`
+}
+
+type kmlCoords struct {
+	Coordinates string `xml:"coordinates"`
+}
+
+type kmlPolygon struct {
+	Outer kmlCoords   `xml:"outerBoundaryIs>LinearRing"`
+	Inner []kmlCoords `xml:"innerBoundaryIs>LinearRing"`
+}
+
+func kmlCoordinates(flat []float64, stride int) string {
+	parts := make([]string, 0, len(flat)/stride)
+	for i := 0; i+1 < len(flat); i += stride {
+		parts = append(parts, formatCoord(flat[i])+","+formatCoord(flat[i+1]))
+	}
+	return strings.Join(parts, " ")
+}
+
+func (e *kmlExporter) write(row *repository.ExportRow) error {
+	g, err := wkt.Unmarshal(row.WKT)
+	if err != nil {
+		return fmt.Errorf("problem %d: %w", row.ProblemID, err)
+	}
+
+	var geometry kmlGeometry
+	switch v := g.(type) {
+	case *geom.Point:
+		geometry.Point = &kmlCoords{kmlCoordinates(v.FlatCoords(), v.Stride())}
+	case *geom.LineString:
+		geometry.LineString = &kmlCoords{kmlCoordinates(v.FlatCoords(), v.Stride())}
+	case *geom.Polygon:
+		polygon := &kmlPolygon{}
+		for i := 0; i < v.NumLinearRings(); i++ {
+			ring := v.LinearRing(i)
+			coords := kmlCoords{kmlCoordinates(ring.FlatCoords(), ring.Stride())}
+			if i == 0 {
+				polygon.Outer = coords
+			} else {
+				polygon.Inner = append(polygon.Inner, coords)
+			}
+		}
+		geometry.Polygon = polygon
+	default:
+		return fmt.Errorf("problem %d: unsupported geometry %T", row.ProblemID, g)
+	}
+
+	return e.enc.Encode(kmlPlacemark{
+		Name:        row.Name,
+		Description: row.Description,
+		Data: []kmlData{
+			{Name: "problem_id", Value: strconv.Itoa(row.ProblemID)},
+			{Name: "type", Value: entities.ProblemTypeMap[row.TypeID]},
+			{Name: "status", Value: row.Status},
+			{Name: "importance", Value: formatCoord(row.Importance)},
+			{Name: "address", Value: row.Address},
+			{Name: "created_at", Value: formatCreatedAt(row.CreatedAt)},
+		},
+		kmlGeometry: geometry,
+	})
+}
+
+func (e *kmlExporter) end() error {
+	if err := e.enc.Flush(); err != nil {
+		return err
+	}
+	_, err := io.WriteString(e.w, "</Document></kml>\n")
+	return err
+}
+
+// GPX, lines and polygons become a waypoint at their representative point
+
+type gpxExporter struct {
+	w   io.Writer
+	enc *xml.Encoder
+}
+
+func newGPXExporter(w io.Writer) problemExporter {
+	return &gpxExporter{w: w, enc: xml.NewEncoder(w)}
+}
+
+type gpxWaypoint struct {
+	XMLName xml.Name `xml:"wpt"`
+	Lat     string   `xml:"lat,attr"`
+	Lon     string   `xml:"lon,attr"`
+	Time    string   `xml:"time,omitempty"`
+	Name    string   `xml:"name"`
+	Desc    string   `xml:"desc,omitempty"`
+	Type    string   `xml:"type,omitempty"`
+}
+
+func (e *gpxExporter) begin() error {
+	_, err := io.WriteString(e.w, xml.Header+
+		`<gpx version="1.1" creator="geomap" xmlns="http://www.topografix.com/GPX/1/1">`)
+	return err
+}
+
+func (e *gpxExporter) write(row *repository.ExportRow) error {
+	return e.enc.Encode(gpxWaypoint{
+		Lat:  formatCoord(row.Lat),
+		Lon:  formatCoord(row.Lon),
+		Time: formatCreatedAt(row.CreatedAt),
+		Name: row.Name,
+		Desc: row.Description,
+		Type: entities.ProblemTypeMap[row.TypeID],
+	})
+}
+
+func (e *gpxExporter) end() error {
+	if err := e.enc.Flush(); err != nil {
+		return err
+	}
+	_, err := io.WriteString(e.w, "</gpx>\n")
+	return err
+}
+
+// Shapefile, one layer per geometry type zipped together. The format needs
+// seekable files, so layers are written to a temp dir and zipped at the end.
+
+const wgs84PRJ = `GEOGCS["GCS_WGS_1984",DATUM["D_WGS_1984",SPHEROID["WGS_1984",6378137.0,298.257223563]],PRIMEM["Greenwich",0.0],UNIT["Degree",0.0174532925199433]]`
+
+var shapefileFields = []shp.Field{
+	shp.NumberField("PROBLEM_ID", 10),
+	shp.StringField("NAME", 254),
+	shp.NumberField("TYPE_ID", 4),
+	shp.StringField("STATUS", 20),
+	shp.FloatField("IMPORTANCE", 8, 2),
+	shp.NumberField("DISTRICT", 12),
+	shp.StringField("ADDRESS", 254),
+	shp.StringField("CREATED_AT", 25),
+}
+
+type shapefileLayer struct {
+	writer *shp.Writer
+	rows   int
+}
+
+type shapefileExporter struct {
+	w      io.Writer
+	dir    string
+	layers map[shp.ShapeType]*shapefileLayer
+}
+
+func newShapefileExporter(w io.Writer) problemExporter {
+	return &shapefileExporter{w: w, layers: make(map[shp.ShapeType]*shapefileLayer)}
+}
+
+func (e *shapefileExporter) begin() error {
+	dir, err := os.MkdirTemp("", "geomap-export-")
+	if err != nil {
+		return err
+	}
+	e.dir = dir
+	return nil
+}
+
+func shapeFromGeometry(g geom.T) (shp.Shape, shp.ShapeType, error) {
+	toPoints := func(flat []float64, stride int) []shp.Point {
+		points := make([]shp.Point, 0, len(flat)/stride)
+		for i := 0; i+1 < len(flat); i += stride {
+			points = append(points, shp.Point{X: flat[i], Y: flat[i+1]})
+		}
+		return points
+	}
+
+	switch v := g.(type) {
+	case *geom.Point:
+		return &shp.Point{X: v.X(), Y: v.Y()}, shp.POINT, nil
+	case *geom.LineString:
+		return shp.NewPolyLine([][]shp.Point{toPoints(v.FlatCoords(), v.Stride())}), shp.POLYLINE, nil
+	case *geom.Polygon:
+		parts := make([][]shp.Point, 0, v.NumLinearRings())
+		for i := 0; i < v.NumLinearRings(); i++ {
+			ring := v.LinearRing(i)
+			parts = append(parts, toPoints(ring.FlatCoords(), ring.Stride()))
+		}
+		polygon := shp.Polygon(*shp.NewPolyLine(parts))
+		return &polygon, shp.POLYGON, nil
+	default:
+		return nil, 0, fmt.Errorf("unsupported geometry %T", g)
+	}
+}
+
+func shapeLayerName(t shp.ShapeType) string {
+	switch t {
+	case shp.POINT:
+		return "problems_points"
+	case shp.POLYLINE:
+		return "problems_lines"
+	default:
+		return "problems_polygons"
+	}
+}
+
+func (e *shapefileExporter) layer(t shp.ShapeType) (*shapefileLayer, error) {
+	if l, ok := e.layers[t]; ok {
+		return l, nil
+	}
+
+	writer, err := shp.Create(filepath.Join(e.dir, shapeLayerName(t)+".shp"), t)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := writer.SetFields(shapefileFields); err != nil {
+		writer.Close()
+		return nil, err
+	}
+
+	l := &shapefileLayer{writer: writer}
+	e.layers[t] = l
+	return l, nil
+}
+
+// truncate cuts s to at most n bytes without splitting a utf-8 rune
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	s = s[:n]
+	for !utf8.ValidString(s) {
+		s = s[:len(s)-1]
+	}
+	return s
+}
+
+func (e *shapefileExporter) write(row *repository.ExportRow) error {
+	g, err := wkt.Unmarshal(row.WKT)
+	if err != nil {
+		return fmt.Errorf("problem %d: %w", row.ProblemID, err)
+	}
+
+	shape, shapeType, err := shapeFromGeometry(g)
+	if err != nil {
+		return fmt.Errorf("problem %d: %w", row.ProblemID, err)
+	}
+
+	l, err := e.layer(shapeType)
+	if err != nil {
+		return err
+	}
+
+	l.writer.Write(shape)
+	values := []interface{}{
+		row.ProblemID,
+		truncate(row.Name, 254),
+		row.TypeID,
+		truncate(row.Status, 20),
+		row.Importance,
+		row.DistrictID,
+		truncate(row.Address, 254),
+		formatCreatedAt(row.CreatedAt),
+	}
+	for i, v := range values {
+		if err := l.writer.WriteAttribute(l.rows, i, v); err != nil {
+			return fmt.Errorf("problem %d: %w", row.ProblemID, err)
+		}
+	}
+	l.rows++
+
+	return nil
+}
+
+func (e *shapefileExporter) close() {
+	for _, l := range e.layers {
+		l.writer.Close()
+	}
+	os.RemoveAll(e.dir)
+}
+
+func (e *shapefileExporter) end() error {
+	defer os.RemoveAll(e.dir)
+
+	zw := zip.NewWriter(e.w)
+	for _, t := range []shp.ShapeType{shp.POINT, shp.POLYLINE, shp.POLYGON} {
+		l, ok := e.layers[t]
+		if !ok {
+			continue
+		}
+		l.writer.Close()
+
+		name := shapeLayerName(t)
+		// go-shp writes the attribute table as "<name>dbf", without the dot
+		files := map[string]string{name + ".shp": name + ".shp", name + ".shx": name + ".shx", name + "dbf": name + ".dbf"}
+		for src, dst := range files {
+			if err := zipFile(zw, filepath.Join(e.dir, src), dst); err != nil {
+				return err
+			}
+		}
+
+		sidecars := map[string]string{".prj": wgs84PRJ, ".cpg": "UTF-8"}
+		for ext, content := range sidecars {
+			f, err := zw.Create(name + ext)
+			if err != nil {
+				return err
+			}
+			if _, err := io.WriteString(f, content); err != nil {
+				return err
+			}
+		}
+	}
+
+	return zw.Close()
+}
+
+func zipFile(zw *zip.Writer, path, name string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(dst, src)
+	return err
+}
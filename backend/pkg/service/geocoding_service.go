@@ -0,0 +1,76 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/rwrrioe/geomap/backend/pkg/repository"
+	"github.com/twpayne/go-geom"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultSearchLimit = 10
+	maxSearchLimit     = 50
+	// minimal word similarity for an address to be trusted as a report location
+	minResolveScore = 0.6
+)
+
+type GeocodingService struct {
+	repo repository.ProblemRepository
+}
+
+func NewGeocodingService(repo repository.ProblemRepository) *GeocodingService {
+	return &GeocodingService{
+		repo: repo,
+	}
+}
+
+func (g *GeocodingService) Reverse(ctx context.Context, lat, lon float64) (*repository.GeocodedAddress, error) {
+	if lat < -90 || lat > 90 || lon < -180 || lon > 180 {
+		return nil, fmt.Errorf("coordinates out of range")
+	}
+
+	point := geom.NewPointFlat(geom.XY, []float64{lon, lat})
+	address, err := g.repo.ReverseGeocode(ctx, *point)
+	if errors.Is(err, repository.ErrAddressNotFound) {
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &address, nil
+}
+
+func (g *GeocodingService) Search(ctx context.Context, query string, limit int) ([]repository.GazetteerMatch, error) {
+	if len([]rune(query)) < 3 {
+		return nil, fmt.Errorf("query must be at least 3 characters")
+	}
+
+	if limit <= 0 {
+		limit = defaultSearchLimit
+	}
+
+	if limit > maxSearchLimit {
+		limit = maxSearchLimit
+	}
+
+	return g.repo.SearchGazetteer(ctx, query, limit)
+}
+
+// Resolve picks the best gazetteer match for a free-form address
+func (g *GeocodingService) Resolve(ctx context.Context, address string) (*repository.GazetteerMatch, error) {
+	matches, err := g.Search(ctx, address, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(matches) == 0 || matches[0].Score < minResolveScore {
+		return nil, fmt.Errorf("address %q not found", address)
+	}
+
+	return &matches[0], nil
+}
@@ -0,0 +1,198 @@
+package service
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"strings"
+
+	"github.com/rwrrioe/geomap/backend/pkg/entities"
+	"github.com/rwrrioe/geomap/backend/pkg/repository"
+)
+
+const (
+	heatTileSize = 256
+	// web mercator stops at this latitude so the world is square
+	mercatorMaxLat = 85.0511287798
+	// alpha fades in over the lowest part of the ramp so the edges blend into the map
+	heatFadeIn = 0.25
+)
+
+const (
+	HeatRampHeat    = "heat"
+	HeatRampViridis = "viridis"
+	HeatRampReds    = "reds"
+)
+
+// heatRamps are colour stops spread evenly from the lowest to the highest density
+var heatRamps = map[string][]color.NRGBA{
+	HeatRampHeat: {
+		{0, 0, 255, 255},
+		{0, 255, 255, 255},
+		{0, 255, 0, 255},
+		{255, 255, 0, 255},
+		{255, 0, 0, 255},
+	},
+	HeatRampViridis: {
+		{68, 1, 84, 255},
+		{59, 82, 139, 255},
+		{33, 145, 140, 255},
+		{94, 201, 98, 255},
+		{253, 231, 37, 255},
+	},
+	HeatRampReds: {
+		{254, 224, 210, 255},
+		{252, 146, 114, 255},
+		{222, 45, 38, 255},
+		{165, 15, 21, 255},
+	},
+}
+
+// parseHeatRamp resolves a ramp name or a comma list of #rrggbb / #rrggbbaa stops
+func parseHeatRamp(raw string) ([]color.NRGBA, error) {
+	if raw == "" {
+		raw = HeatRampHeat
+	}
+
+	if ramp, ok := heatRamps[raw]; ok {
+		return ramp, nil
+	}
+
+	parts := strings.Split(raw, ",")
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("unknown colour ramp %q", raw)
+	}
+
+	ramp := make([]color.NRGBA, 0, len(parts))
+	for _, part := range parts {
+		b, err := hex.DecodeString(strings.TrimPrefix(strings.TrimSpace(part), "#"))
+		if err != nil || (len(b) != 3 && len(b) != 4) {
+			return nil, fmt.Errorf("invalid ramp colour %q", part)
+		}
+
+		c := color.NRGBA{R: b[0], G: b[1], B: b[2], A: 255}
+		if len(b) == 4 {
+			c.A = b[3]
+		}
+		ramp = append(ramp, c)
+	}
+
+	return ramp, nil
+}
+
+// rampColor interpolates the ramp at v in (0, 1]
+func rampColor(ramp []color.NRGBA, v float64) color.NRGBA {
+	pos := v * float64(len(ramp)-1)
+	i := int(pos)
+	if i >= len(ramp)-1 {
+		return ramp[len(ramp)-1]
+	}
+
+	t := pos - float64(i)
+	a, b := ramp[i], ramp[i+1]
+	lerp := func(x, y uint8) uint8 {
+		return uint8(math.Round(float64(x) + (float64(y)-float64(x))*t))
+	}
+
+	return color.NRGBA{R: lerp(a.R, b.R), G: lerp(a.G, b.G), B: lerp(a.B, b.B), A: lerp(a.A, b.A)}
+}
+
+// worldPixel projects lon/lat to web mercator pixels of the whole world at zoom z
+func worldPixel(lon, lat float64, z int) (float64, float64) {
+	lat = math.Max(-mercatorMaxLat, math.Min(mercatorMaxLat, lat))
+	size := float64(heatTileSize) * math.Exp2(float64(z))
+	sin := math.Sin(lat * math.Pi / 180)
+
+	x := (lon + 180) / 360 * size
+	y := (0.5 - math.Log((1+sin)/(1-sin))/(4*math.Pi)) * size
+	return x, y
+}
+
+func worldLonLat(px, py float64, z int) (float64, float64) {
+	size := float64(heatTileSize) * math.Exp2(float64(z))
+
+	lon := px/size*360 - 180
+	lat := math.Atan(math.Sinh(math.Pi*(1-2*py/size))) * 180 / math.Pi
+	return lon, lat
+}
+
+// heatTileBBox is the tile extent padded by the kernel radius, so points just
+// outside the tile still warm its edges and neighbouring tiles line up
+func heatTileBBox(z, x, y, radius int) entities.BBox {
+	minX := float64(x*heatTileSize - radius)
+	minY := float64(y*heatTileSize - radius)
+	maxX := float64((x+1)*heatTileSize + radius)
+	maxY := float64((y+1)*heatTileSize + radius)
+
+	minLon, maxLat := worldLonLat(minX, minY, z)
+	maxLon, minLat := worldLonLat(maxX, maxY, z)
+	return entities.BBox{
+		MinLon: math.Max(minLon, -180),
+		MinLat: minLat,
+		MaxLon: math.Min(maxLon, 180),
+		MaxLat: maxLat,
+	}
+}
+
+// renderHeatTile sums a quartic kernel of every source weighted by importance times
+// geometry weight and colours the density. scale is the density drawn with the last ramp
+// stop, it is fixed rather than taken from the tile so adjacent tiles share one scale.
+func renderHeatTile(sources []repository.HeatSource, z, x, y, radius int, scale float64, ramp []color.NRGBA) ([]byte, error) {
+	density := make([]float64, heatTileSize*heatTileSize)
+	originX, originY := float64(x*heatTileSize), float64(y*heatTileSize)
+	r := float64(radius)
+
+	for _, s := range sources {
+		wx, wy := worldPixel(s.Lon, s.Lat, z)
+		cx, cy := wx-originX, wy-originY
+
+		intensity := s.Importance
+		if intensity <= 0 {
+			intensity = 1
+		}
+		if s.Weight > 0 {
+			intensity *= s.Weight
+		}
+
+		x0, x1 := int(math.Max(0, math.Floor(cx-r))), int(math.Min(heatTileSize-1, math.Ceil(cx+r)))
+		y0, y1 := int(math.Max(0, math.Floor(cy-r))), int(math.Min(heatTileSize-1, math.Ceil(cy+r)))
+		for py := y0; py <= y1; py++ {
+			dy := float64(py) + 0.5 - cy
+			for px := x0; px <= x1; px++ {
+				dx := float64(px) + 0.5 - cx
+				d2 := (dx*dx + dy*dy) / (r * r)
+				if d2 >= 1 {
+					continue
+				}
+				k := 1 - d2
+				density[py*heatTileSize+px] += intensity * k * k
+			}
+		}
+	}
+
+	img := image.NewNRGBA(image.Rect(0, 0, heatTileSize, heatTileSize))
+	for i, d := range density {
+		if d <= 0 {
+			continue
+		}
+
+		v := math.Min(d/scale, 1)
+		c := rampColor(ramp, v)
+		if v < heatFadeIn {
+			c.A = uint8(float64(c.A) * v / heatFadeIn)
+		}
+		img.SetNRGBA(i%heatTileSize, i/heatTileSize, c)
+	}
+
+	var buf bytes.Buffer
+	encoder := png.Encoder{CompressionLevel: png.BestSpeed}
+	if err := encoder.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
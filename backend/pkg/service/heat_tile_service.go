@@ -0,0 +1,228 @@
+package service
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"github.com/rwrrioe/geomap/backend/pkg/repository"
+)
+
+const (
+	defaultHeatTileRadius = 25
+	minHeatTileRadius     = 5
+	maxHeatTileRadius     = 100
+	// density drawn with the last ramp stop, roughly two important problems on one spot
+	defaultHeatTileScale = 20
+	maxHeatTileScale     = 10000
+	// created inside the configured directory, cleanup never leaves it
+	heatTileCacheDir = "geomap-heatmap-tiles"
+	// disk budget of the current version tree, tiles past it are rendered on every request
+	heatTileCacheBytes = 512 << 20
+)
+
+type HeatTileQuery struct {
+	Z, X, Y int
+	// kernel radius in screen pixels
+	Radius int
+	// ramp name or comma list of hex colours, see parseHeatRamp
+	Ramp string
+	// density drawn with the last ramp colour
+	Scale  float64
+	Filter repository.ProblemFilter
+}
+
+// HeatTileService renders kernel density PNG tiles and keeps them on disk under
+// dir/geomap-heatmap-tiles/{data version}/{params}/{z}/{x}/{y}.png, a new data version
+// drops the trees of older versions. Every scale, ramp and filter gets its own tree, so
+// the bytes cached for the current version are capped.
+type HeatTileService struct {
+	repo repository.ProblemRepository
+	dir  string
+	// guards the newest version seen and the bytes cached for it, trees of older versions are removed
+	mu      sync.Mutex
+	version int64
+	size    int64
+}
+
+func NewHeatTileService(repo repository.ProblemRepository, dir string) *HeatTileService {
+	if dir == "" {
+		dir = os.TempDir()
+	}
+
+	return &HeatTileService{
+		repo: repo,
+		dir:  filepath.Join(dir, heatTileCacheDir),
+	}
+}
+
+func validateHeatTileQuery(q *HeatTileQuery) error {
+	if err := validateTileCoords(q.Z, q.X, q.Y); err != nil {
+		return err
+	}
+
+	if q.Radius == 0 {
+		q.Radius = defaultHeatTileRadius
+	}
+
+	if q.Radius < minHeatTileRadius || q.Radius > maxHeatTileRadius {
+		return fmt.Errorf("radius must be between %d and %d pixels", minHeatTileRadius, maxHeatTileRadius)
+	}
+
+	if q.Scale == 0 {
+		q.Scale = defaultHeatTileScale
+	}
+
+	if math.IsNaN(q.Scale) || q.Scale < 0 || q.Scale > maxHeatTileScale {
+		return fmt.Errorf("scale must be positive and at most %d", maxHeatTileScale)
+	}
+
+	return nil
+}
+
+// paramsDir names the tile set of everything but the tile coordinates
+func (q HeatTileQuery) paramsDir() string {
+	sum := sha1.Sum([]byte(fmt.Sprintf("r=%d|ramp=%s|scale=%g|%s", q.Radius, q.Ramp, q.Scale, q.Filter.Key())))
+	return hex.EncodeToString(sum[:8])
+}
+
+// GetTile returns the PNG tile and the data version it was rendered from
+func (h *HeatTileService) GetTile(ctx context.Context, q HeatTileQuery) ([]byte, int64, error) {
+	if err := validateHeatTileQuery(&q); err != nil {
+		return nil, 0, err
+	}
+
+	ramp, err := parseHeatRamp(q.Ramp)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	version, err := h.repo.GetDataVersion(ctx, repository.DataVersionProblems)
+	if err != nil {
+		return nil, 0, err
+	}
+	h.useVersion(version.Version)
+
+	path := filepath.Join(h.dir, strconv.FormatInt(version.Version, 10), q.paramsDir(),
+		strconv.Itoa(q.Z), strconv.Itoa(q.X), strconv.Itoa(q.Y)+".png")
+
+	tile, err := os.ReadFile(path)
+	if err == nil {
+		return tile, version.Version, nil
+	}
+
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, 0, err
+	}
+
+	sources, err := h.repo.ListHeatSourcesInBBox(ctx, heatTileBBox(q.Z, q.X, q.Y, q.Radius), q.Filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	tile, err = renderHeatTile(sources, q.Z, q.X, q.Y, q.Radius, q.Scale, ramp)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// a failed or skipped write only costs a render on the next request
+	if h.reserve(version.Version, len(tile)) {
+		if err := writeFileAtomic(path, tile); err != nil {
+			log.Printf("heatmap tile cache write failed: %v", err)
+		}
+	}
+
+	return tile, version.Version, nil
+}
+
+// useVersion removes the tile trees of older data versions the first time a newer version is seen.
+// Requests still on an older version neither move the current version back nor delete newer trees.
+func (h *HeatTileService) useVersion(version int64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if version <= h.version {
+		return
+	}
+	h.version = version
+	// the tree may be left from an earlier run
+	h.size = dirSize(filepath.Join(h.dir, strconv.FormatInt(version, 10)))
+
+	entries, err := os.ReadDir(h.dir)
+	if err != nil {
+		return
+	}
+
+	for _, e := range entries {
+		v, err := strconv.ParseInt(e.Name(), 10, 64)
+		if err != nil || !e.IsDir() || v >= version {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(h.dir, e.Name())); err != nil {
+			log.Printf("heatmap tile cache cleanup failed: %v", err)
+		}
+	}
+}
+
+// reserve accounts n bytes of a tile of the current version, it reports false once the
+// budget is spent or for a request still on an older version
+func (h *HeatTileService) reserve(version int64, n int) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if version != h.version || h.size+int64(n) > heatTileCacheBytes {
+		return false
+	}
+	h.size += int64(n)
+
+	return true
+}
+
+// dirSize sums the file sizes under dir, a missing dir is empty
+func dirSize(dir string) int64 {
+	var size int64
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			size += info.Size()
+		}
+		return nil
+	})
+
+	return size
+}
+
+// writeFileAtomic renames a temp file into place so readers never see a partial tile
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tile-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
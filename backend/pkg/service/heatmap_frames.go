@@ -0,0 +1,303 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rwrrioe/geomap/backend/pkg/entities"
+	"github.com/rwrrioe/geomap/backend/pkg/repository"
+)
+
+const (
+	FrameBucketDay   = "day"
+	FrameBucketWeek  = "week"
+	FrameBucketMonth = "month"
+
+	FrameOutputPoints = "points"
+	FrameOutputCells  = "cells"
+
+	// a year of days
+	maxFrames = 366
+	// frames kept in memory, every point entry holds a whole range
+	maxCachedCellFrames  = 2048
+	maxCachedPointRanges = 64
+)
+
+type FrameQuery struct {
+	Bucket string
+	// every frame holds all problems since the start of the range instead of its own window
+	Cumulative bool
+	Output     string
+	CellShape  string
+	CellSize   float64
+	// From is required and is rounded down to the bucket start, To defaults to now;
+	// the remaining fields narrow the problems of every frame
+	Filter repository.ProblemFilter
+}
+
+type HeatMapFrame struct {
+	Start        time.Time                          `json:"start"`
+	End          time.Time                          `json:"end"`
+	ProblemCount int                                `json:"problem_count"`
+	HeatPoints   []entities.HeatPoint               `json:"heat_points,omitempty"`
+	Cells        *entities.GeoJSONFeatureCollection `json:"cells,omitempty"`
+}
+
+type HeatMapFrames struct {
+	Bucket     string         `json:"bucket"`
+	Cumulative bool           `json:"cumulative"`
+	Version    int64          `json:"version"`
+	Frames     []HeatMapFrame `json:"frames"`
+}
+
+type cachedCellFrame struct {
+	cells *entities.GeoJSONFeatureCollection
+	count int
+}
+
+// cachedPointFrames holds the heat points of a whole range ordered by bucket with the
+// bucket start of each, frames are slices of points
+type cachedPointFrames struct {
+	points  []entities.HeatPoint
+	buckets []time.Time
+}
+
+type cachedFrame[T any] struct {
+	version int64
+	value   T
+}
+
+// frameCache keeps frames by key for one data version, the cache is dropped as a whole when full
+type frameCache[T any] struct {
+	mu     sync.RWMutex
+	limit  int
+	frames map[string]cachedFrame[T]
+}
+
+func (c *frameCache[T]) get(key string, version int64) (T, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	frame, ok := c.frames[key]
+	return frame.value, ok && frame.version == version
+}
+
+func (c *frameCache[T]) put(key string, version int64, value T) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.frames == nil || len(c.frames) >= c.limit {
+		c.frames = make(map[string]cachedFrame[T])
+	}
+	c.frames[key] = cachedFrame[T]{version: version, value: value}
+}
+
+// bucketStart rounds t down to the start of its day, ISO week or month in UTC
+func bucketStart(t time.Time, bucket string) time.Time {
+	t = t.UTC()
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+
+	switch bucket {
+	case FrameBucketWeek:
+		offset := (int(day.Weekday()) + 6) % 7 // monday is 0
+		return day.AddDate(0, 0, -offset)
+	case FrameBucketMonth:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	default:
+		return day
+	}
+}
+
+func nextBucket(t time.Time, bucket string) time.Time {
+	switch bucket {
+	case FrameBucketWeek:
+		return t.AddDate(0, 0, 7)
+	case FrameBucketMonth:
+		return t.AddDate(0, 1, 0)
+	default:
+		return t.AddDate(0, 0, 1)
+	}
+}
+
+// frameWindows splits [from, to) into whole buckets, the last one may end after to.
+// Whole buckets keep the cache keys stable while the current bucket is still filling.
+func frameWindows(from, to time.Time, bucket string) ([][2]time.Time, error) {
+	var windows [][2]time.Time
+	for start := bucketStart(from, bucket); start.Before(to); start = nextBucket(start, bucket) {
+		if len(windows) == maxFrames {
+			return nil, fmt.Errorf("range has more than %d %s frames", maxFrames, bucket)
+		}
+		windows = append(windows, [2]time.Time{start, nextBucket(start, bucket)})
+	}
+	return windows, nil
+}
+
+func validateFrameQuery(q *FrameQuery) error {
+	switch q.Bucket {
+	case FrameBucketDay, FrameBucketWeek, FrameBucketMonth:
+	case "":
+		q.Bucket = FrameBucketWeek
+	default:
+		return fmt.Errorf("unknown frame bucket %q", q.Bucket)
+	}
+
+	switch q.Output {
+	case FrameOutputPoints, FrameOutputCells:
+	case "":
+		q.Output = FrameOutputPoints
+	default:
+		return fmt.Errorf("unknown frame output %q", q.Output)
+	}
+
+	if q.Output == FrameOutputCells {
+		if q.CellShape == "" {
+			q.CellShape = repository.CellShapeHex
+		}
+
+		if q.CellSize == 0 {
+			q.CellSize = defaultCellSize
+		}
+
+		if q.CellSize < minCellSize || q.CellSize > maxCellSize {
+			return fmt.Errorf("cell size must be between %d and %d meters", minCellSize, maxCellSize)
+		}
+	}
+
+	if q.Filter.From == nil {
+		return fmt.Errorf("from is required for heatmap frames")
+	}
+
+	return nil
+}
+
+// GetFrames slices the filtered problems by creation time into day, week or month frames.
+// Point frames of a range come from one query grouped by bucket and cell frames from one
+// aggregation per frame, both are cached in memory for the data version, so replaying a
+// range only queries again after data changes.
+func (h *HeatMapService) GetFrames(ctx context.Context, q FrameQuery) (*HeatMapFrames, error) {
+	if err := validateFrameQuery(&q); err != nil {
+		return nil, err
+	}
+
+	to := time.Now()
+	if q.Filter.To != nil {
+		to = *q.Filter.To
+	}
+
+	windows, err := frameWindows(*q.Filter.From, to, q.Bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	version, err := h.repo.GetDataVersion(ctx, repository.DataVersionProblems)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &HeatMapFrames{
+		Bucket:     q.Bucket,
+		Cumulative: q.Cumulative,
+		Version:    version.Version,
+		Frames:     make([]HeatMapFrame, 0, len(windows)),
+	}
+
+	if len(windows) == 0 {
+		return result, nil
+	}
+
+	if q.Output == FrameOutputPoints {
+		filter := q.Filter
+		filter.From, filter.To = &windows[0][0], &windows[len(windows)-1][1]
+		points, err := h.pointRange(ctx, filter, q.Bucket, version.Version)
+		if err != nil {
+			return nil, err
+		}
+
+		// cumulative frames keep first at the start of the range
+		var first, last int
+		for _, w := range windows {
+			start, end := w[0], w[1]
+			for last < len(points.buckets) && points.buckets[last].Before(end) {
+				last++
+			}
+			heatPoints := points.points[first:last]
+			result.Frames = append(result.Frames, HeatMapFrame{
+				Start:        start,
+				End:          end,
+				ProblemCount: len(heatPoints),
+				HeatPoints:   heatPoints,
+			})
+
+			if !q.Cumulative {
+				first = last
+			}
+		}
+
+		return result, nil
+	}
+
+	for _, w := range windows {
+		start, end := w[0], w[1]
+		filter := q.Filter
+		filter.From, filter.To = &start, &end
+		if q.Cumulative {
+			filter.From = &windows[0][0]
+		}
+
+		frame := HeatMapFrame{Start: start, End: end}
+		frame.Cells, frame.ProblemCount, err = h.cellFrame(ctx, filter, q.CellShape, q.CellSize, version.Version)
+		if err != nil {
+			return nil, err
+		}
+
+		result.Frames = append(result.Frames, frame)
+	}
+
+	return result, nil
+}
+
+func (h *HeatMapService) pointRange(ctx context.Context, filter repository.ProblemFilter, bucket string, version int64) (cachedPointFrames, error) {
+	key := fmt.Sprintf("%s|%s", filter.Key(), bucket)
+	if cached, ok := h.pointFrames.get(key, version); ok {
+		return cached, nil
+	}
+
+	sources, err := h.repo.ListHeatSourceFrames(ctx, filter, bucket)
+	if err != nil {
+		return cachedPointFrames{}, err
+	}
+
+	frames := cachedPointFrames{
+		points:  make([]entities.HeatPoint, 0, len(sources)),
+		buckets: make([]time.Time, 0, len(sources)),
+	}
+	for _, s := range sources {
+		frames.points = append(frames.points, newHeatPoint(s.HeatSource))
+		frames.buckets = append(frames.buckets, s.Bucket)
+	}
+
+	h.pointFrames.put(key, version, frames)
+	return frames, nil
+}
+
+func (h *HeatMapService) cellFrame(ctx context.Context, filter repository.ProblemFilter, shape string, size float64, version int64) (*entities.GeoJSONFeatureCollection, int, error) {
+	key := fmt.Sprintf("%s|%s|%g", filter.Key(), shape, size)
+	if cached, ok := h.cellFrames.get(key, version); ok {
+		return cached.cells, cached.count, nil
+	}
+
+	cells, err := h.GetAggregatedHeatMap(ctx, shape, size, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	count, err := h.repo.CountFilteredProblems(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	h.cellFrames.put(key, version, cachedCellFrame{cells: cells, count: count})
+	return cells, count, nil
+}
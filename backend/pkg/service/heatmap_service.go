@@ -2,47 +2,92 @@ package service
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sync"
+	"time"
 
 	"github.com/rwrrioe/geomap/backend/pkg/entities"
 	"github.com/rwrrioe/geomap/backend/pkg/repository"
 	"gorm.io/gorm"
 )
 
+const (
+	// web mercator resolution of a 256px tile at zoom 0, meters per pixel
+	mercatorResolution   = 156543.03392
+	defaultClusterRadius = 60
+	maxClusterZoom       = 22
+
+	defaultCellSize = 500
+	minCellSize     = 50
+	maxCellSize     = 10000
+)
+
+// HeatMapRetention bounds the cached_heatmaps table, the newest Keep heatmaps
+// are always kept and older ones are deleted once they are MaxAge old
+type HeatMapRetention struct {
+	Keep   int
+	MaxAge time.Duration
+}
+
+const (
+	defaultHeatMapKeep   = 3
+	defaultHeatMapMaxAge = 24 * time.Hour
+)
+
 type HeatMapService struct {
-	repo repository.ProblemRepository
+	repo      repository.ProblemRepository
+	retention HeatMapRetention
+	// serializes rebuilds so concurrent requests on a stale cache build it once
+	mu          sync.Mutex
+	cellFrames  frameCache[cachedCellFrame]
+	pointFrames frameCache[cachedPointFrames]
 }
 
-func NewHeatMapService(repo repository.ProblemRepository) *HeatMapService {
+func NewHeatMapService(repo repository.ProblemRepository, retention HeatMapRetention) *HeatMapService {
+	if retention.Keep <= 0 {
+		retention.Keep = defaultHeatMapKeep
+	}
+
+	if retention.MaxAge <= 0 {
+		retention.MaxAge = defaultHeatMapMaxAge
+	}
+
 	return &HeatMapService{
-		repo: repo,
+		repo:        repo,
+		retention:   retention,
+		cellFrames:  frameCache[cachedCellFrame]{limit: maxCachedCellFrames},
+		pointFrames: frameCache[cachedPointFrames]{limit: maxCachedPointRanges},
+	}
+}
+
+func newHeatPoint(p repository.HeatSource) entities.HeatPoint {
+	return entities.HeatPoint{
+		Category: p.TypeID,
+		Point: entities.Point{
+			DistrictId: p.DistrictID,
+			Id:         p.ProblemID,
+			Lon:        p.Lon,
+			Lat:        p.Lat,
+			Importance: p.Importance,
+			Weight:     p.Weight,
+		},
 	}
 }
 
-func (h *HeatMapService) BuildHeatMap(ctx context.Context) error {
-	points, err := h.repo.ListProblems(ctx)
+// BuildHeatMap caches a heatmap of the filtered problems tagged with the data version it saw
+func (h *HeatMapService) BuildHeatMap(ctx context.Context, filter repository.ProblemFilter, version int64) error {
+	sources, err := h.repo.ListHeatSources(ctx, filter)
 	if err != nil {
 		return err
 	}
-	heatPoints := make([]entities.HeatPoint, 0, len(*points))
-
-	for _, p := range *points {
-		coords := p.Geom.Geom.Coords()
-		lon := coords[0]
-		lat := coords[1]
-
-		heatPoint := entities.HeatPoint{
-			Category: p.TypeID,
-			Point: entities.Point{
-				DistrictId: p.DistrictId,
-				Id:         p.ProblemID,
-				Lon:        lon,
-				Lat:        lat,
-				Importance: p.Importance,
-			},
-		}
+	heatPoints := make([]entities.HeatPoint, 0, len(sources))
 
-		heatPoints = append(heatPoints, heatPoint)
+	for _, p := range sources {
+		heatPoints = append(heatPoints, newHeatPoint(p))
 	}
 
 	heatMap := entities.HeatMap{
@@ -50,7 +95,7 @@ func (h *HeatMapService) BuildHeatMap(ctx context.Context) error {
 		HeatPoints: heatPoints,
 	}
 
-	err = h.repo.CacheHeatMap(ctx, &heatMap)
+	err = h.repo.CacheHeatMap(ctx, &heatMap, filter.Key(), version)
 	if err != nil {
 		return err
 	}
@@ -58,19 +103,118 @@ func (h *HeatMapService) BuildHeatMap(ctx context.Context) error {
 	return nil
 }
 
-func (h *HeatMapService) GetHeatMap(ctx context.Context) (*entities.CachedHeatMap, error) {
-	heatmap, err := h.repo.GetHeatMap(ctx)
+// GetHeatMap returns the cached heatmap of the filter, rebuilding it when problems changed since it was built
+func (h *HeatMapService) GetHeatMap(ctx context.Context, filter repository.ProblemFilter) (*entities.CachedHeatMap, error) {
+	key := filter.Key()
+	heatmap, fresh, err := h.cachedHeatMap(ctx, key)
+	if err != nil || fresh {
+		return heatmap, err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	// another request may have rebuilt it while we waited
+	heatmap, fresh, err = h.cachedHeatMap(ctx, key)
+	if err != nil || fresh {
+		return heatmap, err
+	}
+
+	version, err := h.repo.GetDataVersion(ctx, repository.DataVersionProblems)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := h.BuildHeatMap(ctx, filter, version.Version); err != nil {
+		return nil, err
+	}
+
+	if _, err := h.repo.PruneHeatMaps(ctx, h.retention.Keep, time.Now().Add(-h.retention.MaxAge)); err != nil {
+		log.Println("failed to prune cached heatmaps:", err)
+	}
+
+	return h.repo.GetHeatMap(ctx, key)
+}
+
+// DataVersion returns the problems change counter, cheap enough to answer conditional requests
+func (h *HeatMapService) DataVersion(ctx context.Context) (entities.DataVersion, error) {
+	return h.repo.GetDataVersion(ctx, repository.DataVersionProblems)
+}
+
+// cachedHeatMap reports whether the latest cached heatmap of the key matches the current data version
+func (h *HeatMapService) cachedHeatMap(ctx context.Context, key string) (*entities.CachedHeatMap, bool, error) {
+	version, err := h.repo.GetDataVersion(ctx, repository.DataVersionProblems)
+	if err != nil {
+		return nil, false, err
+	}
+
+	heatmap, err := h.repo.GetHeatMap(ctx, key)
 	if errors.Is(err, gorm.ErrRecordNotFound) {
-		err := h.BuildHeatMap(ctx)
-		if err != nil {
-			return nil, err
-		}
-		heatmap, err := h.repo.GetHeatMap(ctx)
-		if err != nil {
-			return nil, err
+		return nil, false, nil
+	}
+
+	if err != nil {
+		return nil, false, err
+	}
+
+	return heatmap, heatmap.Version == version.Version, nil
+}
+
+// ListClusters merges problems closer than radius screen pixels at the given zoom
+func (h *HeatMapService) ListClusters(ctx context.Context, bbox entities.BBox, zoom int, radius int) ([]repository.ProblemCluster, error) {
+	if err := bbox.Validate(); err != nil {
+		return nil, err
+	}
+
+	if zoom < 0 || zoom > maxClusterZoom {
+		return nil, fmt.Errorf("zoom must be between 0 and %d", maxClusterZoom)
+	}
+
+	if radius <= 0 {
+		radius = defaultClusterRadius
+	}
+
+	eps := float64(radius) * mercatorResolution / math.Pow(2, float64(zoom))
+	clusters, err := h.repo.ListClusters(ctx, bbox, eps)
+	if err != nil {
+		return nil, err
+	}
+
+	return clusters, nil
+}
+
+// GetAggregatedHeatMap bins the filtered problems into hex or square cells of size meters
+func (h *HeatMapService) GetAggregatedHeatMap(ctx context.Context, shape string, size float64, filter repository.ProblemFilter) (*entities.GeoJSONFeatureCollection, error) {
+	if shape == "" {
+		shape = repository.CellShapeHex
+	}
+
+	if size == 0 {
+		size = defaultCellSize
+	}
+
+	if size < minCellSize || size > maxCellSize {
+		return nil, fmt.Errorf("cell size must be between %d and %d meters", minCellSize, maxCellSize)
+	}
+
+	cells, err := h.repo.AggregateHeatCells(ctx, shape, size, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	features := make([]entities.GeoJSONFeature, 0, len(cells))
+	for _, cell := range cells {
+		categories := make(map[int]int)
+		if err := json.Unmarshal([]byte(cell.Categories), &categories); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal cell categories: %w", err)
 		}
-		return heatmap, nil
+
+		features = append(features, entities.NewFeature(cell.Geometry, entities.HeatCellProperties{
+			ProblemCount:  cell.ProblemCount,
+			ImportanceSum: cell.ImportanceSum,
+			Categories:    categories,
+		}))
 	}
 
-	return heatmap, nil
+	return entities.NewFeatureCollection(features), nil
 }
@@ -0,0 +1,208 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rwrrioe/geomap/backend/pkg/entities"
+	"github.com/rwrrioe/geomap/backend/pkg/repository"
+)
+
+const (
+	maxSnapshotName      = 100
+	defaultSnapshotLimit = 50
+	maxSnapshotLimit     = 500
+)
+
+type SnapshotRef struct {
+	SnapshotID int       `json:"snapshot_id"`
+	Name       *string   `json:"name"`
+	Version    int64     `json:"version"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+type Snapshot struct {
+	SnapshotRef
+	Filter  string           `json:"filter,omitempty"`
+	HeatMap entities.HeatMap `json:"heatmap"`
+}
+
+func newSnapshot(s *entities.CachedHeatMap) *Snapshot {
+	return &Snapshot{
+		SnapshotRef: newSnapshotRef(s),
+		Filter:      s.FilterKey,
+		HeatMap:     s.HeatMap,
+	}
+}
+
+type ImportanceChange struct {
+	ProblemID      int     `json:"problem_id"`
+	DistrictID     int     `json:"district_id"`
+	Category       int     `json:"category"`
+	ImportanceFrom float64 `json:"importance_from"`
+	ImportanceTo   float64 `json:"importance_to"`
+}
+
+type DistrictDelta struct {
+	DistrictID      int     `json:"district_id"`
+	CountFrom       int     `json:"count_from"`
+	CountTo         int     `json:"count_to"`
+	CountDelta      int     `json:"count_delta"`
+	ImportanceFrom  float64 `json:"importance_from"`
+	ImportanceTo    float64 `json:"importance_to"`
+	ImportanceDelta float64 `json:"importance_delta"`
+}
+
+type SnapshotDiff struct {
+	From      SnapshotRef          `json:"from"`
+	To        SnapshotRef          `json:"to"`
+	Added     []entities.HeatPoint `json:"added"`
+	Removed   []entities.HeatPoint `json:"removed"`
+	Changed   []ImportanceChange   `json:"changed"`
+	Districts []DistrictDelta      `json:"districts"`
+}
+
+func newSnapshotRef(s *entities.CachedHeatMap) SnapshotRef {
+	return SnapshotRef{
+		SnapshotID: s.HeatMapID,
+		Name:       s.Name,
+		Version:    s.Version,
+		CreatedAt:  s.CreatedAt,
+	}
+}
+
+// SaveSnapshot stores the current heatmap of every problem under name
+func (h *HeatMapService) SaveSnapshot(ctx context.Context, name string) (*Snapshot, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len([]rune(name)) > maxSnapshotName {
+		return nil, fmt.Errorf("snapshot name must be 1 to %d characters", maxSnapshotName)
+	}
+
+	heatmap, err := h.GetHeatMap(ctx, repository.ProblemFilter{})
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot, err := h.repo.SaveSnapshot(ctx, name, heatmap)
+	if err != nil {
+		return nil, err
+	}
+
+	return newSnapshot(snapshot), nil
+}
+
+func (h *HeatMapService) ListSnapshots(ctx context.Context, namedOnly bool, limit int) ([]repository.SnapshotInfo, error) {
+	if limit <= 0 {
+		limit = defaultSnapshotLimit
+	}
+
+	if limit > maxSnapshotLimit {
+		limit = maxSnapshotLimit
+	}
+
+	return h.repo.ListSnapshots(ctx, namedOnly, limit)
+}
+
+func (h *HeatMapService) GetSnapshot(ctx context.Context, id int) (*Snapshot, error) {
+	snapshot, err := h.repo.GetSnapshot(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return newSnapshot(snapshot), nil
+}
+
+// DiffSnapshots compares two snapshots point by point, problems are matched by id
+func (h *HeatMapService) DiffSnapshots(ctx context.Context, fromID, toID int) (*SnapshotDiff, error) {
+	from, err := h.repo.GetSnapshot(ctx, fromID)
+	if err != nil {
+		return nil, err
+	}
+
+	to, err := h.repo.GetSnapshot(ctx, toID)
+	if err != nil {
+		return nil, err
+	}
+
+	diff := diffHeatMaps(&from.HeatMap, &to.HeatMap)
+	diff.From = newSnapshotRef(from)
+	diff.To = newSnapshotRef(to)
+
+	return diff, nil
+}
+
+func diffHeatMaps(from, to *entities.HeatMap) *SnapshotDiff {
+	diff := &SnapshotDiff{
+		Added:   make([]entities.HeatPoint, 0),
+		Removed: make([]entities.HeatPoint, 0),
+		Changed: make([]ImportanceChange, 0),
+	}
+
+	districts := make(map[int]*DistrictDelta)
+	district := func(id int) *DistrictDelta {
+		d, ok := districts[id]
+		if !ok {
+			d = &DistrictDelta{DistrictID: id}
+			districts[id] = d
+		}
+		return d
+	}
+
+	before := make(map[int]entities.HeatPoint, len(from.HeatPoints))
+	for _, p := range from.HeatPoints {
+		before[p.Point.Id] = p
+
+		d := district(p.Point.DistrictId)
+		d.CountFrom++
+		d.ImportanceFrom += p.Point.Importance
+	}
+
+	seen := make(map[int]bool, len(to.HeatPoints))
+	for _, p := range to.HeatPoints {
+		seen[p.Point.Id] = true
+
+		d := district(p.Point.DistrictId)
+		d.CountTo++
+		d.ImportanceTo += p.Point.Importance
+
+		old, ok := before[p.Point.Id]
+		if !ok {
+			diff.Added = append(diff.Added, p)
+			continue
+		}
+
+		if old.Point.Importance != p.Point.Importance {
+			diff.Changed = append(diff.Changed, ImportanceChange{
+				ProblemID:      p.Point.Id,
+				DistrictID:     p.Point.DistrictId,
+				Category:       p.Category,
+				ImportanceFrom: old.Point.Importance,
+				ImportanceTo:   p.Point.Importance,
+			})
+		}
+	}
+
+	for _, p := range from.HeatPoints {
+		if !seen[p.Point.Id] {
+			diff.Removed = append(diff.Removed, p)
+		}
+	}
+
+	diff.Districts = make([]DistrictDelta, 0, len(districts))
+	for _, d := range districts {
+		d.CountDelta = d.CountTo - d.CountFrom
+		d.ImportanceFrom = round2(d.ImportanceFrom)
+		d.ImportanceTo = round2(d.ImportanceTo)
+		d.ImportanceDelta = round2(d.ImportanceTo - d.ImportanceFrom)
+		diff.Districts = append(diff.Districts, *d)
+	}
+
+	sort.Slice(diff.Districts, func(i, j int) bool {
+		return diff.Districts[i].DistrictID < diff.Districts[j].DistrictID
+	})
+
+	return diff
+}
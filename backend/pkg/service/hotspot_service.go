@@ -0,0 +1,186 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+
+	"github.com/rwrrioe/geomap/backend/pkg/entities"
+	"github.com/rwrrioe/geomap/backend/pkg/repository"
+)
+
+const (
+	HotspotValueCount      = "count"
+	HotspotValueImportance = "importance"
+
+	defaultHotspotCellSize = 1000
+	hotspotSignificance    = 0.05
+)
+
+type HotspotQuery struct {
+	CellSize float64
+	Value    string
+	Filter   repository.ProblemFilter
+}
+
+// Hotspot is a grid cell with a statistically significant Gi* score
+type Hotspot struct {
+	Lon          float64 `json:"lon"`
+	Lat          float64 `json:"lat"`
+	Class        string  `json:"class"` // "hot" or "cold"
+	Confidence   int     `json:"confidence"`
+	ZScore       float64 `json:"z_score"`
+	PValue       float64 `json:"p_value"`
+	ProblemCount int     `json:"problem_count"`
+	geometry     string
+}
+
+type HotspotService struct {
+	repo repository.ProblemRepository
+}
+
+func NewHotspotService(repo repository.ProblemRepository) *HotspotService {
+	return &HotspotService{
+		repo: repo,
+	}
+}
+
+func (h *HotspotService) Detect(ctx context.Context, q HotspotQuery) ([]Hotspot, error) {
+	if q.CellSize == 0 {
+		q.CellSize = defaultHotspotCellSize
+	}
+
+	if q.CellSize < minCellSize || q.CellSize > maxCellSize {
+		return nil, fmt.Errorf("cell size must be between %d and %d meters", minCellSize, maxCellSize)
+	}
+
+	if q.Value == "" {
+		q.Value = HotspotValueCount
+	}
+
+	if q.Value != HotspotValueCount && q.Value != HotspotValueImportance {
+		return nil, fmt.Errorf("unknown hotspot value %q", q.Value)
+	}
+
+	cells, err := h.repo.GridCellStats(ctx, q.CellSize, q.Filter)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([]float64, len(cells))
+	for i, cell := range cells {
+		if q.Value == HotspotValueImportance {
+			values[i] = cell.ImportanceSum
+		} else {
+			values[i] = float64(cell.ProblemCount)
+		}
+	}
+
+	scores := getisOrdGiStar(cells, values)
+	hotspots := make([]Hotspot, 0)
+	for i, z := range scores {
+		p := math.Erfc(math.Abs(z) / math.Sqrt2)
+		if p >= hotspotSignificance {
+			continue
+		}
+
+		class := "hot"
+		if z < 0 {
+			class = "cold"
+		}
+
+		hotspots = append(hotspots, Hotspot{
+			Lon:          cells[i].Lon,
+			Lat:          cells[i].Lat,
+			Class:        class,
+			Confidence:   confidenceLevel(p),
+			ZScore:       math.Round(z*100) / 100,
+			PValue:       p,
+			ProblemCount: cells[i].ProblemCount,
+			geometry:     cells[i].Geometry,
+		})
+	}
+
+	return hotspots, nil
+}
+
+func (h *HotspotService) DetectGeoJSON(ctx context.Context, q HotspotQuery) (*entities.GeoJSONFeatureCollection, error) {
+	hotspots, err := h.Detect(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+
+	features := make([]entities.GeoJSONFeature, 0, len(hotspots))
+	for _, hs := range hotspots {
+		features = append(features, entities.NewFeature(hs.geometry, hs))
+	}
+
+	return entities.NewFeatureCollection(features), nil
+}
+
+// hotspotsPromptData renders hotspots as json so the model sees field names
+func hotspotsPromptData(hotspots []Hotspot) string {
+	if len(hotspots) == 0 {
+		return "[]"
+	}
+
+	data, err := json.Marshal(hotspots)
+	if err != nil {
+		return "[]"
+	}
+	return string(data)
+}
+
+// getisOrdGiStar scores every cell against its queen neighbourhood, the cell itself included
+func getisOrdGiStar(cells []repository.GridCellStat, values []float64) []float64 {
+	n := float64(len(cells))
+	scores := make([]float64, len(cells))
+	if n < 2 {
+		return scores
+	}
+
+	index := make(map[[2]int]int, len(cells))
+	var sum, sumSq float64
+	for i, cell := range cells {
+		index[[2]int{cell.I, cell.J}] = i
+		sum += values[i]
+		sumSq += values[i] * values[i]
+	}
+
+	mean := sum / n
+	s := math.Sqrt(sumSq/n - mean*mean)
+	if s == 0 {
+		return scores
+	}
+
+	for i, cell := range cells {
+		var local, weights float64
+		for di := -1; di <= 1; di++ {
+			for dj := -1; dj <= 1; dj++ {
+				j, ok := index[[2]int{cell.I + di, cell.J + dj}]
+				if !ok {
+					continue
+				}
+				local += values[j]
+				weights++
+			}
+		}
+
+		// binary weights, so sum of squared weights equals sum of weights
+		denom := s * math.Sqrt((n*weights-weights*weights)/(n-1))
+		if denom == 0 {
+			continue
+		}
+		scores[i] = (local - mean*weights) / denom
+	}
+
+	return scores
+}
+
+func confidenceLevel(p float64) int {
+	if p < 0.01 {
+		return 99
+	}
+	return 95
+}
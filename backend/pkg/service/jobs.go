@@ -0,0 +1,106 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/rwrrioe/geomap/backend/pkg/repository"
+)
+
+const (
+	JobHeatMapRebuild = "heatmap_rebuild"
+	JobAIAnalysis     = "ai_analysis"
+	JobStaleProblems  = "stale_problems"
+	JobOutboxCleanup  = "outbox_cleanup"
+	JobWebhookCleanup = "webhook_cleanup"
+
+	DefaultStaleProblemAge = 30 * 24 * time.Hour
+)
+
+// NewHeatMapRebuildJob keeps the unfiltered heatmap warm so the first visitor after a
+// change does not pay for the rebuild, it also prunes old cached heatmaps
+func NewHeatMapRebuildJob(h *HeatMapService) Job {
+	return Job{
+		Name:        JobHeatMapRebuild,
+		Description: "rebuild the cached heatmap when problems changed and prune old heatmaps",
+		Schedule:    "*/5 * * * *",
+		Timeout:     5 * time.Minute,
+		Run: func(ctx context.Context) (string, error) {
+			heatmap, err := h.GetHeatMap(ctx, repository.ProblemFilter{})
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("heatmap version %d with %d points", heatmap.Version, len(heatmap.HeatMap.HeatPoints)), nil
+		},
+	}
+}
+
+func NewAIAnalysisJob(ai *AIPredictService) Job {
+	return Job{
+		Name:        JobAIAnalysis,
+		Description: "regenerate the cached AI analysis of the city and every district",
+		Schedule:    "0 3 * * *",
+		Timeout:     time.Hour,
+		Run:         ai.RegenerateAnalysis,
+	}
+}
+
+// NewStaleProblemsJob marks problems still in the created status after maxAge as stale
+func NewStaleProblemsJob(problems *ProblemService, maxAge time.Duration) Job {
+	if maxAge <= 0 {
+		maxAge = DefaultStaleProblemAge
+	}
+
+	return Job{
+		Name:        JobStaleProblems,
+		Description: fmt.Sprintf("mark problems untouched for %s as stale", maxAge),
+		Schedule:    "15 * * * *",
+		Run: func(ctx context.Context) (string, error) {
+			n, err := problems.MarkStale(ctx, maxAge)
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("%d problems marked stale", n), nil
+		},
+	}
+}
+
+func NewOutboxCleanupJob(d *OutboxDispatcher, retention time.Duration) Job {
+	if retention <= 0 {
+		retention = DefaultOutboxRetention
+	}
+
+	return Job{
+		Name:        JobOutboxCleanup,
+		Description: fmt.Sprintf("delete outbox events dispatched more than %s ago", retention),
+		Schedule:    "30 4 * * *",
+		Run: func(ctx context.Context) (string, error) {
+			n, err := d.Prune(ctx, retention)
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("%d outbox events deleted", n), nil
+		},
+	}
+}
+
+// NewWebhookCleanupJob trims the delivery log, dead deliveries are kept
+func NewWebhookCleanupJob(w *WebhookService, retention time.Duration) Job {
+	if retention <= 0 {
+		retention = DefaultWebhookRetention
+	}
+
+	return Job{
+		Name:        JobWebhookCleanup,
+		Description: fmt.Sprintf("delete webhook deliveries that succeeded more than %s ago", retention),
+		Schedule:    "45 4 * * *",
+		Run: func(ctx context.Context) (string, error) {
+			n, err := w.Prune(ctx, retention)
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("%d webhook deliveries deleted", n), nil
+		},
+	}
+}
@@ -0,0 +1,167 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/rwrrioe/geomap/backend/pkg/entities"
+	"github.com/rwrrioe/geomap/backend/pkg/repository"
+)
+
+const (
+	defaultOutboxPollInterval = time.Second
+	outboxBatchSize           = 100
+	// how long a claimed batch is hidden from other dispatchers
+	outboxLease          = time.Minute
+	outboxHandlerTimeout = 10 * time.Second
+	outboxMaxAttempts    = 10
+	outboxMaxBackoff     = 10 * time.Minute
+	// dispatched events are kept this long for inspection and live feed replay
+	DefaultOutboxRetention = 7 * 24 * time.Hour
+)
+
+// EventHandler reacts to a domain event. Delivery is at least once, so a handler may see
+// the same event again after a crash or after another handler of the event failed.
+type EventHandler func(ctx context.Context, event entities.ProblemEvent) error
+
+type outboxHandler struct {
+	name   string
+	handle EventHandler
+}
+
+// OutboxDispatcher delivers events from the outbox to in-process handlers, every replica
+// runs one and claims disjoint batches. A failed handler is retried with exponential
+// backoff, handlers that already succeeded for the event are not called again.
+type OutboxDispatcher struct {
+	repo     repository.ProblemRepository
+	interval time.Duration
+
+	mu       sync.RWMutex
+	handlers []outboxHandler
+}
+
+func NewOutboxDispatcher(repo repository.ProblemRepository, interval time.Duration) *OutboxDispatcher {
+	if interval <= 0 {
+		interval = defaultOutboxPollInterval
+	}
+
+	return &OutboxDispatcher{
+		repo:     repo,
+		interval: interval,
+	}
+}
+
+// Register adds a handler, name identifies it in the delivery records and must stay stable
+func (d *OutboxDispatcher) Register(name string, handle EventHandler) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.handlers = append(d.handlers, outboxHandler{name: name, handle: handle})
+}
+
+// Start polls the outbox until ctx is done
+func (d *OutboxDispatcher) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(d.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if _, err := d.DispatchPending(ctx); err != nil {
+					log.Println("outbox dispatch failed:", err)
+				}
+			}
+		}
+	}()
+}
+
+// DispatchPending delivers due events batch by batch until none are left
+func (d *OutboxDispatcher) DispatchPending(ctx context.Context) (int, error) {
+	var total int
+	for {
+		events, err := d.repo.ClaimOutboxEvents(ctx, outboxBatchSize, outboxLease)
+		if err != nil {
+			return total, err
+		}
+
+		for i := range events {
+			if err := d.deliver(ctx, &events[i]); err != nil {
+				return total, err
+			}
+		}
+
+		total += len(events)
+		if len(events) < outboxBatchSize {
+			return total, nil
+		}
+	}
+}
+
+func outboxBackoff(attempts int) time.Duration {
+	backoff := time.Second << min(attempts, 20)
+	return min(backoff, outboxMaxBackoff)
+}
+
+func (d *OutboxDispatcher) deliver(ctx context.Context, event *entities.OutboxEvent) error {
+	d.mu.RLock()
+	handlers := d.handlers
+	d.mu.RUnlock()
+
+	// handlers see the outbox id, which is stable across redeliveries
+	payload := event.Payload
+	payload.ID = event.EventID
+
+	var failures []string
+	for _, h := range handlers {
+		if slices.Contains(event.Delivered, h.name) {
+			continue
+		}
+
+		if err := runEventHandler(ctx, h, payload); err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", h.name, err))
+			continue
+		}
+		event.Delivered = append(event.Delivered, h.name)
+	}
+
+	now := time.Now()
+	if len(failures) == 0 {
+		event.DispatchedAt = &now
+		event.LastError = ""
+	} else {
+		event.Attempts++
+		event.LastError = fmt.Sprint(failures)
+		event.NextAttemptAt = now.Add(outboxBackoff(event.Attempts))
+		if event.Attempts >= outboxMaxAttempts {
+			event.FailedAt = &now
+			log.Printf("outbox event %d gave up after %d attempts: %s", event.EventID, event.Attempts, event.LastError)
+		}
+	}
+
+	return d.repo.SaveOutboxEvent(ctx, event)
+}
+
+func runEventHandler(ctx context.Context, h outboxHandler, event entities.ProblemEvent) (err error) {
+	ctx, cancel := context.WithTimeout(ctx, outboxHandlerTimeout)
+	defer cancel()
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+
+	return h.handle(ctx, event)
+}
+
+// Prune deletes events dispatched longer than retention ago
+func (d *OutboxDispatcher) Prune(ctx context.Context, retention time.Duration) (int64, error) {
+	return d.repo.PruneOutbox(ctx, time.Now().Add(-retention))
+}
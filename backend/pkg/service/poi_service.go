@@ -0,0 +1,118 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+
+	"github.com/rwrrioe/geomap/backend/pkg/entities"
+	"github.com/rwrrioe/geomap/backend/pkg/repository"
+	"github.com/twpayne/go-geom"
+)
+
+const (
+	DefaultPOIRadius = 300 // meters
+	maxPOIRadius     = 5000
+
+	// reports start in the middle of the 1..10 importance scale,
+	// nearby sensitive pois add at most maxPOIBonus on top
+	baseImportance = 5
+	maxPOIBonus    = 4
+	maxImportance  = 10
+
+	// problems listed per district in the ai prompt
+	poiExposureLimit = 20
+)
+
+type POIService struct {
+	repo   repository.ProblemRepository
+	radius float64
+}
+
+func NewPOIService(repo repository.ProblemRepository, radius float64) *POIService {
+	if radius <= 0 {
+		radius = DefaultPOIRadius
+	}
+
+	return &POIService{
+		repo:   repo,
+		radius: radius,
+	}
+}
+
+func validatePOICategories(categories []string) error {
+	for _, c := range categories {
+		if _, ok := entities.POIWeights[c]; !ok {
+			return fmt.Errorf("unknown poi category %q", c)
+		}
+	}
+	return nil
+}
+
+func (s *POIService) List(ctx context.Context, bbox entities.BBox, categories []string) ([]repository.POIDTO, error) {
+	if err := bbox.Validate(); err != nil {
+		return nil, err
+	}
+
+	if err := validatePOICategories(categories); err != nil {
+		return nil, err
+	}
+
+	return s.repo.ListPOIs(ctx, bbox, categories)
+}
+
+// Near lists pois around a point, radius 0 falls back to the scoring radius
+func (s *POIService) Near(ctx context.Context, lon, lat, radius float64, categories []string) ([]repository.POIDTO, error) {
+	if radius == 0 {
+		radius = s.radius
+	}
+
+	if radius < 0 || radius > maxPOIRadius {
+		return nil, fmt.Errorf("radius must be between 0 and %d meters", maxPOIRadius)
+	}
+
+	if err := validatePOICategories(categories); err != nil {
+		return nil, err
+	}
+
+	point := geom.NewPointFlat(geom.XY, []float64{lon, lat})
+	return s.repo.ListPOIsNear(ctx, *point, radius, categories)
+}
+
+// Importance scores a new report, every sensitive poi within the radius adds its category weight
+func (s *POIService) Importance(ctx context.Context, g geom.T) (float64, error) {
+	counts, err := s.repo.CountNearbyPOIs(ctx, g, s.radius)
+	if err != nil {
+		return 0, err
+	}
+
+	return scoreImportance(counts), nil
+}
+
+func scoreImportance(counts []repository.POICount) float64 {
+	var bonus float64
+	for _, c := range counts {
+		bonus += entities.POIWeights[c.Category] * float64(c.Count)
+	}
+
+	importance := baseImportance + math.Min(bonus, maxPOIBonus)
+	return math.Min(math.Round(importance*100)/100, maxImportance)
+}
+
+// DistrictExposure lists the open problems of a district with sensitive pois nearby
+func (s *POIService) DistrictExposure(ctx context.Context, districtID int) ([]repository.ProblemPOIExposure, error) {
+	return s.repo.DistrictPOIExposure(ctx, districtID, s.radius, poiExposureLimit)
+}
+
+// exposurePromptData renders the exposure as json so the model sees field names
+func exposurePromptData(exposure []repository.ProblemPOIExposure) string {
+	if len(exposure) == 0 {
+		return "[]"
+	}
+	data, err := json.Marshal(exposure)
+	if err != nil {
+		return "[]"
+	}
+	return string(data)
+}
@@ -6,28 +6,183 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"slices"
+	"time"
 
 	"github.com/rwrrioe/geomap/backend/pkg/entities"
 	"github.com/rwrrioe/geomap/backend/pkg/repository"
 	"github.com/twpayne/go-geom"
+	"github.com/twpayne/go-geom/encoding/geojson"
+	"github.com/twpayne/go-geom/xy"
 	"github.com/ybru-tech/georm"
 	"gorm.io/gorm"
 )
 
+// district assignment strategies for reported points
+const (
+	DistrictStrategyContains   = "contains"
+	DistrictStrategyIntersects = "intersects"
+	DistrictStrategySnap       = "snap"
+	DistrictStrategyUnassigned = "unassigned"
+)
+
+// DistrictFallback decides what happens to points outside every district.
+// Strategy "" rejects them, "snap" moves them to the nearest district within
+// Tolerance, "unassigned" tries snapping and otherwise puts them up for review.
+type DistrictFallback struct {
+	Strategy  string
+	Tolerance float64 // snap distance in meters
+}
+
 type ProblemService struct {
-	repo repository.ProblemRepository
+	repo     repository.ProblemRepository
+	fallback DistrictFallback
+	crs      *CRSService
+	pois     *POIService
 }
 
-func NewProblemService(repo repository.ProblemRepository) *ProblemService {
+func NewProblemService(repo repository.ProblemRepository, fallback DistrictFallback, crs *CRSService, pois *POIService) *ProblemService {
 	return &ProblemService{
-		repo: repo,
+		repo:     repo,
+		fallback: fallback,
+		crs:      crs,
+		pois:     pois,
 	}
 }
 
-func (p *ProblemService) NewProblem(ctx context.Context, req entities.CreateProblemForm) error {
-	point := geom.NewPointFlat(geom.XY, []float64{req.Lon, req.Lat})
+// problem statuses that can be set by hand, stale is only set by the sweep
+var settableStatuses = []string{
+	entities.ProblemStatusCreated,
+	entities.ProblemStatusInProgress,
+	entities.ProblemStatusSolved,
+}
 
+type districtAssignment struct {
+	districtID *int
+	strategy   string
+}
+
+func (p *ProblemService) assignDistrict(ctx context.Context, point *geom.Point) (districtAssignment, error) {
 	district, err := p.repo.GetDb().FindDistrict(ctx, *point)
+	if err == nil {
+		return districtAssignment{districtID: &district.District_ID, strategy: DistrictStrategyContains}, nil
+	}
+
+	return p.fallbackDistrict(ctx, point, err)
+}
+
+// fallbackDistrict applies the fallback strategy to a geometry outside every district,
+// err is the error of the regular lookup
+func (p *ProblemService) fallbackDistrict(ctx context.Context, g geom.T, err error) (districtAssignment, error) {
+	if !errors.Is(err, repository.ErrDistrictNotFound) || p.fallback.Strategy == "" {
+		return districtAssignment{}, err
+	}
+
+	if p.fallback.Tolerance > 0 {
+		district, err := p.repo.SnapToDistrict(ctx, g, p.fallback.Tolerance)
+		if err == nil {
+			return districtAssignment{districtID: &district.District_ID, strategy: DistrictStrategySnap}, nil
+		}
+
+		if !errors.Is(err, repository.ErrDistrictNotFound) || p.fallback.Strategy == DistrictStrategySnap {
+			return districtAssignment{}, err
+		}
+	}
+
+	if p.fallback.Strategy == DistrictStrategyUnassigned {
+		return districtAssignment{strategy: DistrictStrategyUnassigned}, nil
+	}
+
+	return districtAssignment{}, err
+}
+
+// assignDistricts resolves every district crossed by a line or polygon,
+// the one with the largest overlap becomes the primary district. A geometry outside every
+// district gets the same fallback as a point, snapping measures from its nearest part.
+func (p *ProblemService) assignDistricts(ctx context.Context, g geom.T) (districtAssignment, []int, error) {
+	districts, err := p.repo.FindIntersectingDistricts(ctx, g)
+	if err != nil {
+		return districtAssignment{}, nil, err
+	}
+
+	if len(districts) == 0 {
+		assignment, err := p.fallbackDistrict(ctx, g, repository.ErrDistrictNotFound)
+		return assignment, nil, err
+	}
+
+	ids := make([]int, 0, len(districts))
+	for _, d := range districts {
+		ids = append(ids, d.District_ID)
+	}
+
+	return districtAssignment{districtID: &ids[0], strategy: DistrictStrategyIntersects}, ids, nil
+}
+
+// problemGeometry builds the report geometry in its input crs from the geojson field or from lat/lon
+func problemGeometry(req *entities.CreateProblemForm) (geom.T, error) {
+	if req.Geometry == "" {
+		return geom.NewPointFlat(geom.XY, []float64{req.Lon, req.Lat}), nil
+	}
+
+	var g geom.T
+	if err := geojson.Unmarshal([]byte(req.Geometry), &g); err != nil {
+		return nil, fmt.Errorf("invalid geometry: %w", err)
+	}
+
+	switch g.(type) {
+	case *geom.Point, *geom.LineString, *geom.Polygon:
+	default:
+		return nil, fmt.Errorf("geometry must be a Point, LineString or Polygon")
+	}
+
+	if g.Layout() != geom.XY {
+		return nil, fmt.Errorf("geometry must be two-dimensional")
+	}
+
+	return g, nil
+}
+
+// NewProblem stores the report and fills in the district it was assigned to and how
+func (p *ProblemService) NewProblem(ctx context.Context, req *entities.CreateProblemForm) error {
+	g, err := problemGeometry(req)
+	if err != nil {
+		return err
+	}
+
+	srid := req.SRID
+	if srid == 0 {
+		srid = repository.SRIDWGS84
+	}
+
+	g, err = p.crs.ToWGS84(ctx, g, srid)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := g.(*geom.Point); !ok {
+		reason, err := p.repo.GeometryInvalidReason(ctx, g)
+		if err != nil {
+			return err
+		}
+		if reason != "" {
+			return fmt.Errorf("invalid geometry: %s", reason)
+		}
+	}
+
+	// lines and polygons are addressed by their centroid
+	centroid, err := xy.Centroid(g)
+	if err != nil {
+		return fmt.Errorf("invalid geometry: %w", err)
+	}
+	req.Lon, req.Lat, req.SRID = centroid.X(), centroid.Y(), repository.SRIDWGS84
+
+	var assignment districtAssignment
+	var districtIDs []int
+	if point, ok := g.(*geom.Point); ok {
+		assignment, err = p.assignDistrict(ctx, point)
+	} else {
+		assignment, districtIDs, err = p.assignDistricts(ctx, g)
+	}
 	if err != nil {
 		return err
 	}
@@ -36,24 +191,53 @@ func (p *ProblemService) NewProblem(ctx context.Context, req entities.CreateProb
 		return fmt.Errorf("invalid problem type")
 	}
 
+	importance, err := p.pois.Importance(ctx, g)
+	if err != nil {
+		return err
+	}
+
+	// a missing address must not block the report
+	point := geom.NewPointFlat(geom.XY, []float64{req.Lon, req.Lat})
+	address, err := p.repo.ReverseGeocode(ctx, *point)
+	if err != nil && !errors.Is(err, repository.ErrAddressNotFound) {
+		return err
+	}
+
 	problem := entities.Problem{
-		DistrictID:  district.District_ID,
-		Geom:        georm.New(point),
+		DistrictID:  assignment.districtID,
+		Geom:        georm.New(g),
 		Name:        req.ProblemName,
 		Description: req.Description,
 		ImageURL:    req.ImageURL,
-		Status:      "created",
+		Importance:  importance,
+		Status:      entities.ProblemStatusCreated,
 		TypeId:      req.TypeID,
+		Address:     address.String(),
+		NeedsReview: assignment.strategy == DistrictStrategyUnassigned,
 	}
 
-	err = p.repo.AddProblem(ctx, problem)
+	if len(districtIDs) > 0 {
+		err = p.repo.AddProblemWithDistricts(ctx, &problem, districtIDs)
+	} else {
+		err = p.repo.AddProblem(ctx, &problem)
+	}
 	if err != nil {
 		return err
 	}
 
+	if assignment.districtID != nil {
+		req.DistrictID = *assignment.districtID
+	}
+	req.DistrictStrategy = assignment.strategy
+
 	return nil
 }
 
+// ListUnassigned returns the review bucket of reports outside every district
+func (p *ProblemService) ListUnassigned(ctx context.Context) (*[]repository.ProblemDTO, error) {
+	return p.repo.ListUnassigned(ctx)
+}
+
 func (p *ProblemService) GetProblem(ctx context.Context, problemId int) (*repository.ProblemDTO, error) {
 	problem, err := p.repo.GetById(ctx, problemId)
 	if errors.Is(err, gorm.ErrRecordNotFound) {
@@ -71,3 +255,51 @@ func (p *ProblemService) ListProblemsByDistrict(ctx context.Context, districtId
 
 	return problems, nil
 }
+
+// UpdateStatus sets the status of a problem, the repository records the change in the outbox
+func (p *ProblemService) UpdateStatus(ctx context.Context, problemID int, status string) (*repository.ProblemDTO, error) {
+	if !slices.Contains(settableStatuses, status) {
+		return nil, fmt.Errorf("status must be one of %v", settableStatuses)
+	}
+
+	if _, err := p.repo.UpdateProblemStatus(ctx, problemID, status); err != nil {
+		return nil, err
+	}
+
+	return p.repo.GetById(ctx, problemID)
+}
+
+// UpdateProblem edits a problem, the repository records the change in the outbox
+func (p *ProblemService) UpdateProblem(ctx context.Context, problemID int, update repository.ProblemUpdate) (*repository.ProblemDTO, error) {
+	if update.Name != nil && *update.Name == "" {
+		return nil, fmt.Errorf("problem name must not be empty")
+	}
+
+	if update.TypeID != nil && !p.repo.IsProblemType(ctx, *update.TypeID) {
+		return nil, fmt.Errorf("invalid problem type")
+	}
+
+	if update.DistrictID != nil && !p.repo.IsDistrict(ctx, *update.DistrictID) {
+		return nil, fmt.Errorf("invalid district")
+	}
+
+	if err := p.repo.UpdateProblem(ctx, problemID, update); err != nil {
+		return nil, err
+	}
+
+	return p.repo.GetById(ctx, problemID)
+}
+
+func (p *ProblemService) DeleteProblem(ctx context.Context, problemID int) error {
+	return p.repo.DeleteProblem(ctx, problemID)
+}
+
+// MarkStale moves problems left in the created status for maxAge to stale
+func (p *ProblemService) MarkStale(ctx context.Context, maxAge time.Duration) (int, error) {
+	ids, err := p.repo.MarkStaleProblems(ctx, time.Now().Add(-maxAge))
+	if err != nil {
+		return 0, err
+	}
+
+	return len(ids), nil
+}
@@ -0,0 +1,343 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rwrrioe/geomap/backend/pkg/entities"
+	"github.com/rwrrioe/geomap/backend/pkg/repository"
+)
+
+const (
+	// a job scheduled "off" only runs when triggered by hand
+	JobScheduleOff = "off"
+
+	defaultJobTimeout  = 10 * time.Minute
+	jobRunsKept        = 100
+	defaultJobRunLimit = 20
+	maxJobRunLimit     = 100
+)
+
+var (
+	ErrJobNotFound = errors.New("job not found")
+	ErrJobRunning  = errors.New("job is already running")
+)
+
+// Job is a periodic task, Run returns a short summary stored with the run
+type Job struct {
+	Name        string
+	Description string
+	Schedule    string
+	Timeout     time.Duration
+	Run         func(ctx context.Context) (string, error)
+}
+
+type JobInfo struct {
+	Name        string           `json:"name"`
+	Description string           `json:"description"`
+	Schedule    string           `json:"schedule"`
+	NextRun     *time.Time       `json:"next_run,omitempty"`
+	LastRun     *entities.JobRun `json:"last_run,omitempty"`
+}
+
+type scheduledJob struct {
+	Job
+	schedule *cronSchedule
+	next     time.Time
+}
+
+// Scheduler runs jobs in process on every replica, an advisory lock per job makes sure
+// only one replica executes a given run and job_runs keeps the history
+type Scheduler struct {
+	repo      repository.ProblemRepository
+	overrides map[string]string
+	instance  string
+
+	mu   sync.Mutex
+	jobs []*scheduledJob
+}
+
+// ParseJobSchedules reads "name=spec;name=spec" overrides, cron specs contain spaces
+func ParseJobSchedules(raw string) (map[string]string, error) {
+	schedules := make(map[string]string)
+	for _, part := range strings.Split(raw, ";") {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+
+		name, spec, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid job schedule %q, expected name=spec", part)
+		}
+		schedules[strings.TrimSpace(name)] = strings.TrimSpace(spec)
+	}
+
+	return schedules, nil
+}
+
+// NewScheduler takes schedule overrides by job name, see ParseJobSchedules
+func NewScheduler(repo repository.ProblemRepository, overrides map[string]string) *Scheduler {
+	host, _ := os.Hostname()
+
+	return &Scheduler{
+		repo:      repo,
+		overrides: overrides,
+		instance:  fmt.Sprintf("%s-%d", host, os.Getpid()),
+	}
+}
+
+func (s *Scheduler) Register(job Job) error {
+	if spec, ok := s.overrides[job.Name]; ok {
+		job.Schedule = spec
+	}
+
+	if job.Timeout <= 0 {
+		job.Timeout = defaultJobTimeout
+	}
+
+	sj := &scheduledJob{Job: job}
+	if job.Schedule != JobScheduleOff {
+		schedule, err := parseCron(job.Schedule)
+		if err != nil {
+			return fmt.Errorf("job %s: %w", job.Name, err)
+		}
+		sj.schedule = schedule
+		sj.next = schedule.Next(time.Now())
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, j := range s.jobs {
+		if j.Name == job.Name {
+			return fmt.Errorf("job %s is already registered", job.Name)
+		}
+	}
+	s.jobs = append(s.jobs, sj)
+
+	return nil
+}
+
+func (s *Scheduler) find(name string) (*scheduledJob, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, j := range s.jobs {
+		if j.Name == name {
+			return j, nil
+		}
+	}
+	return nil, ErrJobNotFound
+}
+
+// Start runs due jobs in the background until ctx is done
+func (s *Scheduler) Start(ctx context.Context) {
+	go func() {
+		timer := time.NewTimer(0)
+		defer timer.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-timer.C:
+				timer.Reset(time.Until(s.dispatch(ctx, now)))
+			}
+		}
+	}()
+}
+
+// dispatch starts the jobs due at now and returns when the next one is due
+func (s *Scheduler) dispatch(ctx context.Context, now time.Time) time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// wake up at least every minute so a clock jump never stalls the loop for long
+	wake := now.Add(time.Minute)
+	for _, j := range s.jobs {
+		// a spec that never matches, e.g. february 30, has no next run
+		if j.schedule == nil || j.next.IsZero() {
+			continue
+		}
+
+		if !j.next.After(now) {
+			go s.runScheduled(ctx, j.Job, j.next)
+			j.next = j.schedule.Next(now)
+		}
+
+		if !j.next.IsZero() && j.next.Before(wake) {
+			wake = j.next
+		}
+	}
+
+	return wake
+}
+
+// runScheduled executes the run planned for slot unless a replica already took it.
+// The lock alone is not enough: a short job may be over before a slower replica wakes up.
+func (s *Scheduler) runScheduled(ctx context.Context, job Job, slot time.Time) {
+	unlock, ok, err := s.repo.TryJobLock(ctx, job.Name)
+	if err != nil {
+		log.Printf("job %s: %v", job.Name, err)
+		return
+	}
+	if !ok {
+		return
+	}
+
+	last, err := s.repo.ListJobRuns(ctx, job.Name, 1)
+	if err != nil {
+		unlock()
+		log.Printf("job %s: %v", job.Name, err)
+		return
+	}
+	if len(last) > 0 && !last[0].StartedAt.Before(slot) {
+		unlock()
+		return
+	}
+
+	run, err := s.start(ctx, job, entities.JobTriggerSchedule)
+	if err != nil {
+		unlock()
+		log.Printf("job %s: %v", job.Name, err)
+		return
+	}
+
+	s.execute(job, run, unlock)
+}
+
+func (s *Scheduler) start(ctx context.Context, job Job, trigger string) (*entities.JobRun, error) {
+	run := &entities.JobRun{
+		Job:       job.Name,
+		Trigger:   trigger,
+		Instance:  s.instance,
+		Status:    entities.JobStatusRunning,
+		StartedAt: time.Now(),
+	}
+
+	if err := s.repo.StartJobRun(ctx, run); err != nil {
+		return nil, err
+	}
+
+	return run, nil
+}
+
+// execute runs the job detached from the request or scheduler context and records the outcome
+func (s *Scheduler) execute(job Job, run *entities.JobRun, unlock func()) {
+	defer unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), job.Timeout)
+	defer cancel()
+
+	output, err := func() (output string, err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("panic: %v", r)
+			}
+		}()
+		return job.Run(ctx)
+	}()
+
+	finished := time.Now()
+	run.FinishedAt = &finished
+	run.Output = output
+	run.Status = entities.JobStatusSucceeded
+	if err != nil {
+		run.Status = entities.JobStatusFailed
+		run.Error = err.Error()
+		log.Printf("job %s failed: %v", job.Name, err)
+	}
+
+	if err := s.repo.FinishJobRun(context.Background(), run, jobRunsKept); err != nil {
+		log.Printf("job %s: failed to record run: %v", job.Name, err)
+	}
+}
+
+// Trigger starts a job now in the background and returns its run as recorded at start
+func (s *Scheduler) Trigger(ctx context.Context, name string) (*entities.JobRun, error) {
+	j, err := s.find(name)
+	if err != nil {
+		return nil, err
+	}
+
+	unlock, ok, err := s.repo.TryJobLock(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrJobRunning
+	}
+
+	run, err := s.start(ctx, j.Job, entities.JobTriggerManual)
+	if err != nil {
+		unlock()
+		return nil, err
+	}
+
+	started := *run
+	go s.execute(j.Job, run, unlock)
+
+	return &started, nil
+}
+
+// ListJobs describes every registered job with its next planned and last recorded run
+func (s *Scheduler) ListJobs(ctx context.Context) ([]JobInfo, error) {
+	last, err := s.repo.LastJobRuns(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	lastByJob := make(map[string]entities.JobRun, len(last))
+	for _, run := range last {
+		lastByJob[run.Job] = run
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	jobs := make([]JobInfo, 0, len(s.jobs))
+	for _, j := range s.jobs {
+		info := JobInfo{
+			Name:        j.Name,
+			Description: j.Description,
+			Schedule:    JobScheduleOff,
+		}
+
+		if j.schedule != nil {
+			info.Schedule = j.schedule.spec
+			next := j.next
+			info.NextRun = &next
+		}
+
+		if run, ok := lastByJob[j.Name]; ok {
+			info.LastRun = &run
+		}
+
+		jobs = append(jobs, info)
+	}
+
+	return jobs, nil
+}
+
+// ListJobRuns returns the newest runs of a job, across all replicas
+func (s *Scheduler) ListJobRuns(ctx context.Context, name string, limit int) ([]entities.JobRun, error) {
+	if _, err := s.find(name); err != nil {
+		return nil, err
+	}
+
+	if limit <= 0 {
+		limit = defaultJobRunLimit
+	}
+
+	if limit > maxJobRunLimit {
+		limit = maxJobRunLimit
+	}
+
+	return s.repo.ListJobRuns(ctx, name, limit)
+}
@@ -0,0 +1,164 @@
+package service
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rwrrioe/geomap/backend/pkg/repository"
+)
+
+const (
+	tileMaxZoom  = 22
+	tileCacheTTL = 5 * time.Minute
+	// upper bound of the tile bytes held in memory, least recently used tiles go first
+	tileCacheBytes = 64 << 20
+	// charged per cached tile on top of its data so empty tiles are bounded too
+	tileEntryOverhead = 256
+)
+
+type tileKey struct {
+	layer   string
+	z, x, y int
+}
+
+type cachedTile struct {
+	key  tileKey
+	data []byte
+	// problems data version the tile was built from, a newer version makes it stale
+	version   int64
+	expiresAt time.Time
+}
+
+func (c *cachedTile) cost() int {
+	return len(c.data) + tileEntryOverhead
+}
+
+type TileService struct {
+	repo repository.ProblemRepository
+
+	mu    sync.Mutex
+	size  int
+	order *list.List // front is the most recently used *cachedTile
+	tiles map[tileKey]*list.Element
+}
+
+func NewTileService(repo repository.ProblemRepository) *TileService {
+	return &TileService{
+		repo:  repo,
+		order: list.New(),
+		tiles: make(map[tileKey]*list.Element),
+	}
+}
+
+func validateTile(layer string, z, x, y int) error {
+	if layer != repository.TileLayerProblems && layer != repository.TileLayerDistricts {
+		return fmt.Errorf("unknown tile layer %q", layer)
+	}
+
+	return validateTileCoords(z, x, y)
+}
+
+func validateTileCoords(z, x, y int) error {
+	if z < 0 || z > tileMaxZoom {
+		return fmt.Errorf("zoom must be between 0 and %d", tileMaxZoom)
+	}
+
+	n := 1 << z
+	if x < 0 || x >= n || y < 0 || y >= n {
+		return fmt.Errorf("tile %d/%d/%d is out of range", z, x, y)
+	}
+
+	return nil
+}
+
+func (t *TileService) GetTile(ctx context.Context, layer string, z, x, y int) ([]byte, error) {
+	if err := validateTile(layer, z, x, y); err != nil {
+		return nil, err
+	}
+
+	// districts do not change at runtime, problem tiles follow the data version
+	var version int64
+	if layer == repository.TileLayerProblems {
+		dataVersion, err := t.repo.GetDataVersion(ctx, repository.DataVersionProblems)
+		if err != nil {
+			return nil, err
+		}
+		version = dataVersion.Version
+	}
+
+	key := tileKey{layer: layer, z: z, x: x, y: y}
+	if data, ok := t.cached(key, version); ok {
+		return data, nil
+	}
+
+	tile, err := t.repo.GetTile(ctx, layer, z, x, y)
+	if err != nil {
+		return nil, err
+	}
+
+	t.store(&cachedTile{key: key, data: tile, version: version, expiresAt: time.Now().Add(tileCacheTTL)})
+
+	return tile, nil
+}
+
+func (t *TileService) cached(key tileKey, version int64) ([]byte, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	elem, ok := t.tiles[key]
+	if !ok {
+		return nil, false
+	}
+
+	tile := elem.Value.(*cachedTile)
+	if tile.version != version || !time.Now().Before(tile.expiresAt) {
+		t.remove(elem)
+		return nil, false
+	}
+
+	t.order.MoveToFront(elem)
+	return tile.data, true
+}
+
+func (t *TileService) store(tile *cachedTile) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if elem, ok := t.tiles[tile.key]; ok {
+		t.remove(elem)
+	}
+
+	t.tiles[tile.key] = t.order.PushFront(tile)
+	t.size += tile.cost()
+
+	now := time.Now()
+	for back := t.order.Back(); back != nil && back != t.order.Front(); back = t.order.Back() {
+		oldest := back.Value.(*cachedTile)
+		if t.size <= tileCacheBytes && now.Before(oldest.expiresAt) {
+			break
+		}
+		t.remove(back)
+	}
+}
+
+// remove must be called with mu held
+func (t *TileService) remove(elem *list.Element) {
+	tile := t.order.Remove(elem).(*cachedTile)
+	delete(t.tiles, tile.key)
+	t.size -= tile.cost()
+}
+
+// InvalidateLayer drops every cached tile of the layer
+func (t *TileService) InvalidateLayer(layer string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for key, elem := range t.tiles {
+		if key.layer == layer {
+			t.remove(elem)
+		}
+	}
+}
@@ -0,0 +1,392 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rwrrioe/geomap/backend/pkg/entities"
+	"github.com/rwrrioe/geomap/backend/pkg/repository"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultWebhookPollInterval = 2 * time.Second
+	webhookBatchSize           = 32
+	webhookWorkers             = 8
+	// long enough for a batch of timed out requests, see webhookBatchSize and webhookWorkers
+	webhookLease       = 2 * time.Minute
+	webhookTimeout     = 10 * time.Second
+	webhookMaxAttempts = 10
+	webhookBaseBackoff = 10 * time.Second
+	webhookMaxBackoff  = time.Hour
+	// part of the response body kept with a failed attempt
+	webhookErrorBody = 512
+
+	webhookSecretBytes = 32
+
+	DefaultWebhookRetention = 7 * 24 * time.Hour
+	defaultWebhookListLimit = 50
+	maxWebhookListLimit     = 500
+)
+
+// headers of a webhook request
+const (
+	WebhookSignatureHeader = "X-Geomap-Signature"
+	WebhookTimestampHeader = "X-Geomap-Timestamp"
+	WebhookEventHeader     = "X-Geomap-Event"
+	WebhookDeliveryHeader  = "X-Geomap-Delivery"
+
+	webhookSignatureScheme = "sha256="
+	webhookUserAgent       = "geomap-webhooks/1"
+)
+
+var ErrInvalidWebhook = errors.New("invalid webhook")
+
+var webhookEventTypes = []string{
+	entities.EventProblemCreated,
+	entities.EventProblemUpdated,
+	entities.EventProblemStatusChanged,
+	entities.EventProblemDeleted,
+}
+
+// WebhookService fans problem events out to partner URLs. Events arrive from the outbox and are
+// queued per matching webhook, a poller sends them signed and retries failures with backoff until
+// they land in the dead letter list.
+type WebhookService struct {
+	repo     repository.ProblemRepository
+	client   *http.Client
+	interval time.Duration
+}
+
+func NewWebhookService(repo repository.ProblemRepository, interval time.Duration) *WebhookService {
+	if interval <= 0 {
+		interval = defaultWebhookPollInterval
+	}
+
+	return &WebhookService{
+		repo:     repo,
+		client:   &http.Client{Timeout: webhookTimeout},
+		interval: interval,
+	}
+}
+
+// SignWebhookPayload is the hex HMAC-SHA256 of "timestamp.body" with the webhook secret,
+// receivers recompute it and reject old timestamps to stop replays
+func SignWebhookPayload(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return webhookSignatureScheme + hex.EncodeToString(mac.Sum(nil))
+}
+
+func generateWebhookSecret() (string, error) {
+	secret := make([]byte, webhookSecretBytes)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(secret), nil
+}
+
+func validateWebhook(webhook *entities.WebhookSubscription) error {
+	u, err := url.Parse(webhook.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%w: url must be an absolute http or https url", ErrInvalidWebhook)
+	}
+
+	for _, t := range webhook.EventTypes {
+		if !slices.Contains(webhookEventTypes, t) {
+			return fmt.Errorf("%w: unknown event type %q, expected one of %v", ErrInvalidWebhook, t, webhookEventTypes)
+		}
+	}
+
+	return nil
+}
+
+// Create stores a webhook, a secret is generated when none is given and returned only here
+func (s *WebhookService) Create(ctx context.Context, webhook *entities.WebhookSubscription) (*entities.WebhookSubscription, error) {
+	if err := validateWebhook(webhook); err != nil {
+		return nil, err
+	}
+
+	if webhook.Secret == "" {
+		secret, err := generateWebhookSecret()
+		if err != nil {
+			return nil, err
+		}
+		webhook.Secret = secret
+	}
+
+	if err := s.repo.AddWebhook(ctx, webhook); err != nil {
+		return nil, err
+	}
+
+	return webhook, nil
+}
+
+func (s *WebhookService) Get(ctx context.Context, id int) (*entities.WebhookSubscription, error) {
+	webhook, err := s.repo.GetWebhook(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	webhook.Secret = ""
+	return webhook, nil
+}
+
+func (s *WebhookService) List(ctx context.Context) ([]entities.WebhookSubscription, error) {
+	webhooks, err := s.repo.ListWebhooks(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range webhooks {
+		webhooks[i].Secret = ""
+	}
+	return webhooks, nil
+}
+
+// Update replaces the settings of a webhook, an empty secret keeps the current one
+func (s *WebhookService) Update(ctx context.Context, id int, webhook *entities.WebhookSubscription) (*entities.WebhookSubscription, error) {
+	if err := validateWebhook(webhook); err != nil {
+		return nil, err
+	}
+
+	current, err := s.repo.GetWebhook(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	webhook.WebhookID = id
+	webhook.CreatedAt = current.CreatedAt
+	if webhook.Secret == "" {
+		webhook.Secret = current.Secret
+	}
+
+	if err := s.repo.UpdateWebhook(ctx, webhook); err != nil {
+		return nil, err
+	}
+
+	webhook.Secret = ""
+	return webhook, nil
+}
+
+func (s *WebhookService) Delete(ctx context.Context, id int) error {
+	return s.repo.DeleteWebhook(ctx, id)
+}
+
+// ListDeliveries returns the newest deliveries first, status "dead" is the dead letter list
+func (s *WebhookService) ListDeliveries(ctx context.Context, filter repository.WebhookDeliveryFilter) ([]entities.WebhookDelivery, error) {
+	switch filter.Status {
+	case "", entities.WebhookDeliveryPending, entities.WebhookDeliveryDelivered, entities.WebhookDeliveryDead:
+	default:
+		return nil, fmt.Errorf("%w: unknown delivery status %q", ErrInvalidWebhook, filter.Status)
+	}
+
+	if filter.Limit <= 0 {
+		filter.Limit = defaultWebhookListLimit
+	}
+	filter.Limit = min(filter.Limit, maxWebhookListLimit)
+
+	return s.repo.ListWebhookDeliveries(ctx, filter)
+}
+
+// RetryDelivery sends a dead or delivered event again on the next poll
+func (s *WebhookService) RetryDelivery(ctx context.Context, id int64) (*entities.WebhookDelivery, error) {
+	return s.repo.RetryWebhookDelivery(ctx, id)
+}
+
+// HandleEvent is the outbox handler, it queues the event for every active webhook it matches
+func (s *WebhookService) HandleEvent(ctx context.Context, e entities.ProblemEvent) error {
+	webhooks, err := s.repo.ListWebhooks(ctx, true)
+	if err != nil {
+		return err
+	}
+
+	var deliveries []entities.WebhookDelivery
+	for _, webhook := range webhooks {
+		filter := EventFilter{
+			Types:       webhook.EventTypes,
+			DistrictIDs: webhook.DistrictIDs,
+			TypeIDs:     webhook.TypeIDs,
+		}
+		if !filter.match(e) {
+			continue
+		}
+
+		deliveries = append(deliveries, entities.WebhookDelivery{
+			WebhookID:     webhook.WebhookID,
+			EventID:       e.ID,
+			EventType:     e.Type,
+			Payload:       e,
+			Status:        entities.WebhookDeliveryPending,
+			NextAttemptAt: time.Now(),
+		})
+	}
+
+	return s.repo.AddWebhookDeliveries(ctx, deliveries)
+}
+
+// Start polls for due deliveries until ctx is done
+func (s *WebhookService) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if _, err := s.DeliverPending(ctx); err != nil {
+					log.Println("webhook delivery failed:", err)
+				}
+			}
+		}
+	}()
+}
+
+// DeliverPending sends due deliveries batch by batch until none are left
+func (s *WebhookService) DeliverPending(ctx context.Context) (int, error) {
+	var total int
+	for {
+		deliveries, err := s.repo.ClaimWebhookDeliveries(ctx, webhookBatchSize, webhookLease)
+		if err != nil {
+			return total, err
+		}
+
+		if err := s.deliverBatch(ctx, deliveries); err != nil {
+			return total, err
+		}
+
+		total += len(deliveries)
+		if len(deliveries) < webhookBatchSize {
+			return total, nil
+		}
+	}
+}
+
+func (s *WebhookService) deliverBatch(ctx context.Context, deliveries []entities.WebhookDelivery) error {
+	webhooks := make(map[int]*entities.WebhookSubscription)
+	for _, d := range deliveries {
+		if _, ok := webhooks[d.WebhookID]; ok {
+			continue
+		}
+
+		webhook, err := s.repo.GetWebhook(ctx, d.WebhookID)
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		webhooks[d.WebhookID] = webhook
+	}
+
+	// one slow receiver must not hold up the others
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		saveErr error
+		slots   = make(chan struct{}, webhookWorkers)
+	)
+	for i := range deliveries {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(d *entities.WebhookDelivery) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			s.attempt(ctx, webhooks[d.WebhookID], d)
+			if err := s.repo.SaveWebhookDelivery(ctx, d); err != nil {
+				mu.Lock()
+				saveErr = err
+				mu.Unlock()
+			}
+		}(&deliveries[i])
+	}
+	wg.Wait()
+
+	return saveErr
+}
+
+func webhookBackoff(attempts int) time.Duration {
+	backoff := webhookBaseBackoff << min(attempts-1, 20)
+	return min(backoff, webhookMaxBackoff)
+}
+
+// attempt sends the delivery once and updates its state
+func (s *WebhookService) attempt(ctx context.Context, webhook *entities.WebhookSubscription, d *entities.WebhookDelivery) {
+	now := time.Now()
+	if webhook == nil || !webhook.Active {
+		d.Status = entities.WebhookDeliveryDead
+		d.LastError = "webhook is disabled or deleted"
+		return
+	}
+
+	d.Attempts++
+	d.ResponseStatus, d.LastError = s.post(ctx, webhook, d)
+	if d.LastError == "" {
+		d.Status = entities.WebhookDeliveryDelivered
+		d.DeliveredAt = &now
+		return
+	}
+
+	if d.Attempts >= webhookMaxAttempts {
+		d.Status = entities.WebhookDeliveryDead
+		log.Printf("webhook delivery %d to %s is dead after %d attempts: %s", d.DeliveryID, webhook.URL, d.Attempts, d.LastError)
+		return
+	}
+	d.NextAttemptAt = now.Add(webhookBackoff(d.Attempts))
+}
+
+// post returns the response status and an error message, empty when the receiver answered 2xx
+func (s *WebhookService) post(ctx context.Context, webhook *entities.WebhookSubscription, d *entities.WebhookDelivery) (int, string) {
+	body, err := json.Marshal(d.Payload)
+	if err != nil {
+		return 0, err.Error()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err.Error()
+	}
+
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", webhookUserAgent)
+	req.Header.Set(WebhookEventHeader, d.EventType)
+	req.Header.Set(WebhookDeliveryHeader, strconv.FormatInt(d.DeliveryID, 10))
+	req.Header.Set(WebhookTimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(WebhookSignatureHeader, SignWebhookPayload(webhook.Secret, timestamp, body))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, err.Error()
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		io.Copy(io.Discard, resp.Body)
+		return resp.StatusCode, ""
+	}
+
+	snippet, _ := io.ReadAll(io.LimitReader(resp.Body, webhookErrorBody))
+	return resp.StatusCode, fmt.Sprintf("%s: %s", resp.Status, bytes.TrimSpace(snippet))
+}
+
+// Prune deletes deliveries that succeeded longer than retention ago
+func (s *WebhookService) Prune(ctx context.Context, retention time.Duration) (int64, error) {
+	return s.repo.PruneWebhookDeliveries(ctx, time.Now().Add(-retention))
+}
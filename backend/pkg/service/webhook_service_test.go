@@ -0,0 +1,390 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rwrrioe/geomap/backend/pkg/entities"
+	"github.com/rwrrioe/geomap/backend/pkg/repository"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// fakeWebhookRepo keeps webhooks and deliveries in memory, other repository methods are not used
+type fakeWebhookRepo struct {
+	repository.ProblemRepository
+
+	mu         sync.Mutex
+	webhooks   map[int]entities.WebhookSubscription
+	deliveries []entities.WebhookDelivery
+}
+
+func newFakeWebhookRepo(webhooks ...entities.WebhookSubscription) *fakeWebhookRepo {
+	repo := &fakeWebhookRepo{webhooks: make(map[int]entities.WebhookSubscription)}
+	for _, w := range webhooks {
+		repo.webhooks[w.WebhookID] = w
+	}
+	return repo
+}
+
+func (f *fakeWebhookRepo) GetWebhook(ctx context.Context, id int) (*entities.WebhookSubscription, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	w, ok := f.webhooks[id]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return &w, nil
+}
+
+func (f *fakeWebhookRepo) ListWebhooks(ctx context.Context, activeOnly bool) ([]entities.WebhookSubscription, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var webhooks []entities.WebhookSubscription
+	for _, w := range f.webhooks {
+		if w.Active || !activeOnly {
+			webhooks = append(webhooks, w)
+		}
+	}
+	return webhooks, nil
+}
+
+func (f *fakeWebhookRepo) AddWebhookDeliveries(ctx context.Context, deliveries []entities.WebhookDelivery) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, d := range deliveries {
+		d.DeliveryID = int64(len(f.deliveries) + 1)
+		f.deliveries = append(f.deliveries, d)
+	}
+	return nil
+}
+
+func (f *fakeWebhookRepo) ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]entities.WebhookDelivery, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var claimed []entities.WebhookDelivery
+	now := time.Now()
+	for i := range f.deliveries {
+		d := &f.deliveries[i]
+		if d.Status != entities.WebhookDeliveryPending || d.NextAttemptAt.After(now) || len(claimed) == limit {
+			continue
+		}
+		d.NextAttemptAt = now.Add(lease)
+		claimed = append(claimed, *d)
+	}
+	return claimed, nil
+}
+
+func (f *fakeWebhookRepo) SaveWebhookDelivery(ctx context.Context, delivery *entities.WebhookDelivery) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for i := range f.deliveries {
+		if f.deliveries[i].DeliveryID == delivery.DeliveryID {
+			f.deliveries[i] = *delivery
+		}
+	}
+	return nil
+}
+
+func (f *fakeWebhookRepo) delivery(id int64) entities.WebhookDelivery {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.deliveries[id-1]
+}
+
+// makeDue moves the next attempt of a delivery into the past instead of waiting out the backoff
+func (f *fakeWebhookRepo) makeDue(id int64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.deliveries[id-1].NextAttemptAt = time.Now().Add(-time.Second)
+}
+
+func testEvent(id int64) entities.ProblemEvent {
+	return entities.ProblemEvent{
+		ID:         id,
+		Type:       entities.EventProblemCreated,
+		ProblemID:  42,
+		DistrictID: 3,
+		TypeID:     1,
+		Name:       "pothole",
+		Status:     entities.ProblemStatusCreated,
+		OccurredAt: time.Now().UTC(),
+	}
+}
+
+func TestWebhookDeliverySignature(t *testing.T) {
+	const secret = "s3cret"
+
+	received := make(chan entities.ProblemEvent, 1)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("read body: %v", err)
+		}
+
+		timestamp, err := strconv.ParseInt(r.Header.Get(WebhookTimestampHeader), 10, 64)
+		if err != nil {
+			t.Errorf("timestamp header %q: %v", r.Header.Get(WebhookTimestampHeader), err)
+		}
+
+		if got, want := r.Header.Get(WebhookSignatureHeader), SignWebhookPayload(secret, timestamp, body); got != want {
+			t.Errorf("signature = %q, want %q", got, want)
+		}
+
+		if got := r.Header.Get(WebhookEventHeader); got != entities.EventProblemCreated {
+			t.Errorf("event header = %q, want %q", got, entities.EventProblemCreated)
+		}
+
+		if got := r.Header.Get(WebhookDeliveryHeader); got != "1" {
+			t.Errorf("delivery header = %q, want 1", got)
+		}
+
+		var event entities.ProblemEvent
+		if err := json.Unmarshal(body, &event); err != nil {
+			t.Errorf("decode body: %v", err)
+		}
+		received <- event
+	}))
+	defer receiver.Close()
+
+	repo := newFakeWebhookRepo(entities.WebhookSubscription{WebhookID: 1, URL: receiver.URL, Secret: secret, Active: true})
+	s := NewWebhookService(repo, 0)
+
+	if err := s.HandleEvent(context.Background(), testEvent(7)); err != nil {
+		t.Fatalf("HandleEvent: %v", err)
+	}
+
+	if n, err := s.DeliverPending(context.Background()); err != nil || n != 1 {
+		t.Fatalf("DeliverPending = %d, %v, want 1 delivery", n, err)
+	}
+
+	event := <-received
+	if event.ID != 7 || event.ProblemID != 42 {
+		t.Errorf("received event %d of problem %d, want event 7 of problem 42", event.ID, event.ProblemID)
+	}
+
+	if d := repo.delivery(1); d.Status != entities.WebhookDeliveryDelivered || d.ResponseStatus != http.StatusOK {
+		t.Errorf("delivery status %s with response %d, want delivered with 200", d.Status, d.ResponseStatus)
+	}
+}
+
+func TestWebhookSignatureChangesWithSecretAndTimestamp(t *testing.T) {
+	body := []byte(`{"id":1}`)
+	sig := SignWebhookPayload("a", 100, body)
+
+	if sig == SignWebhookPayload("b", 100, body) {
+		t.Error("signature does not depend on the secret")
+	}
+
+	if sig == SignWebhookPayload("a", 101, body) {
+		t.Error("signature does not depend on the timestamp")
+	}
+}
+
+func TestWebhookRetriesServerErrorsWithBackoff(t *testing.T) {
+	const failures = 3
+
+	var calls atomic.Int32
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) <= failures {
+			http.Error(w, "try later", http.StatusServiceUnavailable)
+		}
+	}))
+	defer receiver.Close()
+
+	repo := newFakeWebhookRepo(entities.WebhookSubscription{WebhookID: 1, URL: receiver.URL, Secret: "s", Active: true})
+	s := NewWebhookService(repo, 0)
+	if err := s.HandleEvent(context.Background(), testEvent(1)); err != nil {
+		t.Fatalf("HandleEvent: %v", err)
+	}
+
+	for attempt := 1; attempt <= failures; attempt++ {
+		before := time.Now()
+		if _, err := s.DeliverPending(context.Background()); err != nil {
+			t.Fatalf("DeliverPending: %v", err)
+		}
+
+		d := repo.delivery(1)
+		if d.Status != entities.WebhookDeliveryPending || d.Attempts != attempt {
+			t.Fatalf("after attempt %d: status %s with %d attempts", attempt, d.Status, d.Attempts)
+		}
+
+		if d.ResponseStatus != http.StatusServiceUnavailable || d.LastError == "" {
+			t.Errorf("after attempt %d: response %d, error %q", attempt, d.ResponseStatus, d.LastError)
+		}
+
+		// the next attempt waits out the backoff
+		wait := d.NextAttemptAt.Sub(before)
+		if backoff := webhookBackoff(attempt); wait < backoff || wait > backoff+time.Second {
+			t.Errorf("after attempt %d: next attempt in %s, want %s", attempt, wait, backoff)
+		}
+
+		if n, _ := s.DeliverPending(context.Background()); n != 0 {
+			t.Errorf("after attempt %d: delivery retried before its backoff", attempt)
+		}
+		repo.makeDue(1)
+	}
+
+	if _, err := s.DeliverPending(context.Background()); err != nil {
+		t.Fatalf("DeliverPending: %v", err)
+	}
+
+	d := repo.delivery(1)
+	if d.Status != entities.WebhookDeliveryDelivered || d.Attempts != failures+1 || d.DeliveredAt == nil {
+		t.Errorf("final status %s with %d attempts, want delivered with %d", d.Status, d.Attempts, failures+1)
+	}
+
+	if got := calls.Load(); got != failures+1 {
+		t.Errorf("receiver called %d times, want %d", got, failures+1)
+	}
+}
+
+func TestWebhookBackoffGrowsAndCaps(t *testing.T) {
+	if webhookBackoff(1) != webhookBaseBackoff {
+		t.Errorf("first backoff = %s, want %s", webhookBackoff(1), webhookBaseBackoff)
+	}
+
+	for attempts := 2; attempts < 30; attempts++ {
+		prev, next := webhookBackoff(attempts-1), webhookBackoff(attempts)
+		if next != min(2*prev, webhookMaxBackoff) {
+			t.Errorf("backoff after %d attempts = %s, previous %s", attempts, next, prev)
+		}
+	}
+}
+
+func TestWebhookDeadAfterMaxAttempts(t *testing.T) {
+	var calls atomic.Int32
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		http.Error(w, "broken", http.StatusInternalServerError)
+	}))
+	defer receiver.Close()
+
+	repo := newFakeWebhookRepo(entities.WebhookSubscription{WebhookID: 1, URL: receiver.URL, Secret: "s", Active: true})
+	s := NewWebhookService(repo, 0)
+	if err := s.HandleEvent(context.Background(), testEvent(1)); err != nil {
+		t.Fatalf("HandleEvent: %v", err)
+	}
+
+	for attempt := 1; attempt <= webhookMaxAttempts; attempt++ {
+		if _, err := s.DeliverPending(context.Background()); err != nil {
+			t.Fatalf("DeliverPending: %v", err)
+		}
+
+		d := repo.delivery(1)
+		want := entities.WebhookDeliveryPending
+		if attempt == webhookMaxAttempts {
+			want = entities.WebhookDeliveryDead
+		}
+		if d.Status != want {
+			t.Fatalf("after attempt %d: status %s, want %s", attempt, d.Status, want)
+		}
+		repo.makeDue(1)
+	}
+
+	if n, _ := s.DeliverPending(context.Background()); n != 0 {
+		t.Errorf("dead delivery was claimed again")
+	}
+
+	if got := calls.Load(); got != webhookMaxAttempts {
+		t.Errorf("receiver called %d times, want %d", got, webhookMaxAttempts)
+	}
+
+	if d := repo.delivery(1); d.Attempts != webhookMaxAttempts || d.ResponseStatus != http.StatusInternalServerError {
+		t.Errorf("dead delivery has %d attempts and response %d", d.Attempts, d.ResponseStatus)
+	}
+}
+
+func TestWebhookFilters(t *testing.T) {
+	repo := newFakeWebhookRepo(
+		entities.WebhookSubscription{WebhookID: 1, URL: "http://a", Active: true, DistrictIDs: entities.IntList{3}},
+		entities.WebhookSubscription{WebhookID: 2, URL: "http://b", Active: true, DistrictIDs: entities.IntList{4}},
+		entities.WebhookSubscription{WebhookID: 3, URL: "http://c", Active: true, EventTypes: entities.StringList{entities.EventProblemDeleted}},
+		entities.WebhookSubscription{WebhookID: 4, URL: "http://d", Active: false},
+	)
+	s := NewWebhookService(repo, 0)
+
+	if err := s.HandleEvent(context.Background(), testEvent(1)); err != nil {
+		t.Fatalf("HandleEvent: %v", err)
+	}
+
+	if len(repo.deliveries) != 1 || repo.deliveries[0].WebhookID != 1 {
+		t.Errorf("queued %+v, want one delivery for webhook 1", repo.deliveries)
+	}
+}
+
+// TestAddWebhookDeliveriesDeduplicates needs a PostgreSQL database, e.g.
+// GEOMAP_TEST_DATABASE_DSN="host=localhost user=postgres dbname=geomap_test sslmode=disable".
+// Everything runs in a transaction that is rolled back.
+func TestAddWebhookDeliveriesDeduplicates(t *testing.T) {
+	dsn := os.Getenv("GEOMAP_TEST_DATABASE_DSN")
+	if dsn == "" {
+		t.Skip("GEOMAP_TEST_DATABASE_DSN is not set")
+	}
+
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+
+	tx := db.Begin()
+	defer tx.Rollback()
+
+	if err := tx.AutoMigrate(&entities.WebhookSubscription{}, &entities.WebhookDelivery{}); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+
+	repo := repository.NewProblemRepo(tx)
+	ctx := context.Background()
+	webhook := &entities.WebhookSubscription{URL: "http://localhost/hook", Secret: "s", Active: true}
+	if err := repo.AddWebhook(ctx, webhook); err != nil {
+		t.Fatalf("AddWebhook: %v", err)
+	}
+
+	// the outbox delivers at least once, the same event may reach the handler again
+	s := NewWebhookService(repo, 0)
+	for range 2 {
+		if err := s.HandleEvent(ctx, testEvent(99)); err != nil {
+			t.Fatalf("HandleEvent: %v", err)
+		}
+	}
+
+	deliveries, err := repo.ListWebhookDeliveries(ctx, repository.WebhookDeliveryFilter{WebhookID: webhook.WebhookID, Limit: 10})
+	if err != nil {
+		t.Fatalf("ListWebhookDeliveries: %v", err)
+	}
+
+	if len(deliveries) != 1 || deliveries[0].EventID != 99 {
+		t.Fatalf("got %d deliveries, want one for event 99", len(deliveries))
+	}
+
+	// a different event for the same webhook is still queued
+	if err := s.HandleEvent(ctx, testEvent(100)); err != nil {
+		t.Fatalf("HandleEvent: %v", err)
+	}
+
+	deliveries, err = repo.ListWebhookDeliveries(ctx, repository.WebhookDeliveryFilter{WebhookID: webhook.WebhookID, Limit: 10})
+	if err != nil {
+		t.Fatalf("ListWebhookDeliveries: %v", err)
+	}
+
+	if len(deliveries) != 2 {
+		t.Errorf("got %d deliveries, want 2", len(deliveries))
+	}
+}
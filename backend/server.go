@@ -1,8 +1,11 @@
 package server
 
 import (
+	"context"
 	"log"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
@@ -27,15 +30,88 @@ func (s *HTTPServer) InitServerDefault() error {
 		return err
 	}
 
-	AIService := *service.NewAIPredictService(dbRepo)
-	HeatMapService := *service.NewHeatMapService(dbRepo)
-	ProblemService := *service.NewProblemService(dbRepo)
+	poiRadius, _ := strconv.ParseFloat(os.Getenv("POI_RADIUS"), 64)
+	POIService := service.NewPOIService(dbRepo, poiRadius)
+	HotspotService := service.NewHotspotService(dbRepo)
+	AIService := *service.NewAIPredictService(dbRepo, HotspotService, POIService)
+	heatmapKeep, _ := strconv.Atoi(os.Getenv("HEATMAP_KEEP"))
+	heatmapMaxAge, _ := time.ParseDuration(os.Getenv("HEATMAP_MAX_AGE"))
+	HeatMapService := *service.NewHeatMapService(dbRepo, service.HeatMapRetention{
+		Keep:   heatmapKeep,
+		MaxAge: heatmapMaxAge,
+	})
+	cityEnvelope := entities.AlmatyEnvelope
+	if raw := os.Getenv("CITY_ENVELOPE"); raw != "" {
+		cityEnvelope, err = entities.ParseBBox(raw)
+		if err != nil {
+			return err
+		}
+	}
+	CRSService := service.NewCRSService(dbRepo, cityEnvelope)
+
+	snapTolerance, _ := strconv.ParseFloat(os.Getenv("DISTRICT_SNAP_TOLERANCE"), 64)
+	ProblemService := *service.NewProblemService(dbRepo, service.DistrictFallback{
+		Strategy:  os.Getenv("DISTRICT_FALLBACK"),
+		Tolerance: snapTolerance,
+	}, CRSService, POIService)
+	TileService := service.NewTileService(dbRepo)
+	GeocodingService := service.NewGeocodingService(dbRepo)
+	DistrictService := service.NewDistrictService(dbRepo)
+	CorridorService := service.NewCorridorService(dbRepo, CRSService)
+	ExportService := service.NewExportService(dbRepo)
+	AreaService := service.NewAreaService(dbRepo, CRSService, &AIService)
+	HeatTileService := service.NewHeatTileService(dbRepo, os.Getenv("HEATMAP_TILE_DIR"))
+
+	outboxInterval, _ := time.ParseDuration(os.Getenv("OUTBOX_POLL_INTERVAL"))
+	// every replica tails the outbox for its own live subscribers
+	Events := service.NewEventBus(dbRepo, outboxInterval)
+	if err := Events.Start(context.Background()); err != nil {
+		return err
+	}
+	Dispatcher := service.NewOutboxDispatcher(dbRepo, outboxInterval)
+	webhookInterval, _ := time.ParseDuration(os.Getenv("WEBHOOK_POLL_INTERVAL"))
+	WebhookService := service.NewWebhookService(dbRepo, webhookInterval)
+	Dispatcher.Register("webhooks", WebhookService.HandleEvent)
+	WebhookService.Start(context.Background())
+	Dispatcher.Start(context.Background())
+
+	jobSchedules, err := service.ParseJobSchedules(os.Getenv("JOB_SCHEDULES"))
+	if err != nil {
+		return err
+	}
+	staleProblemAge, _ := time.ParseDuration(os.Getenv("STALE_PROBLEM_AGE"))
+	Scheduler := service.NewScheduler(dbRepo, jobSchedules)
+	for _, job := range []service.Job{
+		service.NewHeatMapRebuildJob(&HeatMapService),
+		service.NewAIAnalysisJob(&AIService),
+		service.NewStaleProblemsJob(&ProblemService, staleProblemAge),
+		service.NewOutboxCleanupJob(Dispatcher, 0),
+		service.NewWebhookCleanupJob(WebhookService, 0),
+	} {
+		if err := Scheduler.Register(job); err != nil {
+			return err
+		}
+	}
+	Scheduler.Start(context.Background())
 
 	handlers := &handlers.HTTPHandlers{
-		User:           &entities.User{Role: "Guest"},
-		AIService:      &AIService,
-		HeatMapService: &HeatMapService,
-		ProblemService: &ProblemService,
+		User:             &entities.User{Role: "Guest"},
+		AIService:        &AIService,
+		HeatMapService:   &HeatMapService,
+		ProblemService:   &ProblemService,
+		TileService:      TileService,
+		HotspotService:   HotspotService,
+		GeocodingService: GeocodingService,
+		DistrictService:  DistrictService,
+		CRSService:       CRSService,
+		CorridorService:  CorridorService,
+		ExportService:    ExportService,
+		POIService:       POIService,
+		AreaService:      AreaService,
+		HeatTileService:  HeatTileService,
+		Scheduler:        Scheduler,
+		Events:           Events,
+		WebhookService:   WebhookService,
 	}
 
 	gin.SetMode(gin.ReleaseMode)
@@ -48,20 +124,57 @@ func (s *HTTPServer) InitServerDefault() error {
 
 	engine.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{frontURL},
-		AllowMethods:     []string{"GET", "POST", "OPTIONS", "PUT", "DELETE"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization"},
-		ExposeHeaders:    []string{"Content-Length"},
+		AllowMethods:     []string{"GET", "POST", "OPTIONS", "PUT", "PATCH", "DELETE"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization", "If-None-Match", "If-Modified-Since"},
+		ExposeHeaders:    []string{"Content-Length", "ETag", "Last-Modified", "X-Heatmap-Version"},
 		AllowCredentials: true,
 	}))
+	engine.Use(handlers.Compress())
 
 	engine.GET("/heatmap", s.HTTPHandlers.GetHeatmap)
 	engine.POST("/heatmap", s.CreateBreefPredicts)
+	engine.GET("/heatmap/clusters", handlers.ListClusters)
+	engine.GET("/heatmap/hotspots", handlers.GetHotspots)
+	engine.GET("/heatmap/frames", handlers.GetHeatmapFrames)
+	engine.GET("/heatmap/tiles/:z/:x/:y", handlers.GetHeatmapTile)
+	engine.POST("/heatmap/snapshots", handlers.SaveHeatmapSnapshot)
+	engine.GET("/heatmap/snapshots", handlers.ListHeatmapSnapshots)
+	engine.GET("/heatmap/snapshots/diff", handlers.DiffHeatmapSnapshots)
+	engine.GET("/heatmap/snapshots/:snapshotID", handlers.GetHeatmapSnapshot)
 	engine.GET("/heatmap/analysis/district/:districtID", handlers.GetDistrictPrediction)
 	engine.GET("/heatmap/analysis/type/:typeID", handlers.GetTypePrediction)
 	engine.GET("/heatmap/analysis/city/:cityID", handlers.GetPredictByCity)
 	engine.GET("/heatmap/districts/:districtID/problems/:problemID", handlers.GetProblem)
 	engine.GET("/heatmap/districts/:districtID/problems", handlers.ListProblemsByDistrict)
 	engine.POST("/heatmap/districts/:districtID/problems", handlers.CreateProblem)
+	engine.GET("/tiles/:layer/:z/:x/:y", handlers.GetTile)
+	engine.POST("/analysis/corridor", handlers.GetCorridorProblems)
+	engine.POST("/analysis/area", handlers.GetAreaAnalysis)
+	engine.GET("/export", handlers.ExportProblems)
+	engine.GET("/districts", handlers.ListDistricts)
+	engine.GET("/districts/:districtID", handlers.GetDistrict)
+	engine.GET("/districts/:districtID/neighbours", handlers.CompareDistrictNeighbours)
+	engine.GET("/problems/review", handlers.ListUnassignedProblems)
+	engine.GET("/problems/feed", handlers.StreamProblems)
+	engine.GET("/geocode/reverse", handlers.ReverseGeocode)
+	engine.GET("/geocode/search", handlers.SearchAddress)
+	engine.GET("/pois", handlers.ListPOIs)
+	engine.GET("/pois/near", handlers.ListPOIsNear)
+
+	admin := engine.Group("/admin", handlers.RequireAdmin(os.Getenv("ADMIN_TOKEN")))
+	admin.GET("/jobs", handlers.ListJobs)
+	admin.POST("/jobs/:job/run", handlers.TriggerJob)
+	admin.GET("/jobs/:job/runs", handlers.ListJobRuns)
+	admin.PUT("/problems/:problemID/status", handlers.UpdateProblemStatus)
+	admin.PATCH("/problems/:problemID", handlers.UpdateProblem)
+	admin.DELETE("/problems/:problemID", handlers.DeleteProblem)
+	admin.POST("/webhooks", handlers.CreateWebhook)
+	admin.GET("/webhooks", handlers.ListWebhooks)
+	admin.GET("/webhooks/:webhookID", handlers.GetWebhook)
+	admin.PUT("/webhooks/:webhookID", handlers.UpdateWebhook)
+	admin.DELETE("/webhooks/:webhookID", handlers.DeleteWebhook)
+	admin.GET("/webhook-deliveries", handlers.ListWebhookDeliveries)
+	admin.POST("/webhook-deliveries/:deliveryID/retry", handlers.RetryWebhookDelivery)
 	engine.Run(":8080")
 	return nil
 }